@@ -0,0 +1,242 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// SourceACME 通过 ACME 自动签发的证书
+const SourceACME = "acme"
+
+// 申请证书失败后的重试间隔，每次连续失败翻倍，避免规则变更和握手反复触发验证
+// 而超出 CA 的失败次数限制（Let's Encrypt 为每个域名每小时 5 次）
+const (
+	acmeRetryMin = 5 * time.Minute
+	acmeRetryMax = time.Hour
+)
+
+// ACMEConfig ACME 自动签发配置
+type ACMEConfig struct {
+	Directory string                 // ACME 目录地址，为空时使用 Let's Encrypt
+	Email     string                 // 注册账号使用的邮箱，可为空
+	CARoot    string                 // 额外信任的 CA 证书文件，用于访问测试环境（如 Pebble）的目录地址
+	CacheDir  string                 // 账号密钥和证书的保存目录
+	Allow     func(host string) bool // 判断是否允许为域名签发证书
+}
+
+// EnableACME 启用 ACME 自动签发
+// 没有手动配置证书的域名在首次握手时通过 TLS-ALPN-01 或 HTTP-01 验证获取证书，到期前自动续期
+func (s *Store) EnableACME(cfg ACMEConfig) error {
+	directory := cfg.Directory
+	if directory == "" {
+		directory = autocert.DefaultACMEDirectory
+	}
+	client := &acme.Client{DirectoryURL: directory}
+	if cfg.CARoot != "" {
+		pemData, err := os.ReadFile(cfg.CARoot)
+		if err != nil {
+			return fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return fmt.Errorf("CA 证书文件 %s 中没有有效的证书", cfg.CARoot)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acmeDir = cfg.CacheDir
+	s.acmeAllow = cfg.Allow
+	s.acme = &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(cfg.CacheDir),
+		Email:  cfg.Email,
+		Client: client,
+		HostPolicy: func(_ context.Context, host string) error {
+			if !cfg.Allow(strings.ToLower(strings.TrimSuffix(host, "."))) {
+				return fmt.Errorf("域名 %s 未在代理规则中配置", host)
+			}
+			return nil
+		},
+	}
+	return nil
+}
+
+// acmeManager 获取 ACME 管理器，未启用时返回 nil
+func (s *Store) acmeManager() *autocert.Manager {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.acme
+}
+
+// HTTPHandler 应答 HTTP-01 验证请求，其余请求交给 next
+func (s *Store) HTTPHandler(next http.Handler) http.Handler {
+	m := s.acmeManager()
+	if m == nil {
+		return next
+	}
+	return m.HTTPHandler(next)
+}
+
+// NextProtos HTTPS 监听器的 ALPN 协议列表，启用 ACME 时包含 TLS-ALPN-01 验证使用的 acme-tls/1
+func (s *Store) NextProtos() []string {
+	protos := []string{"h2", "http/1.1"}
+	if s.acmeManager() != nil {
+		protos = append(protos, acme.ALPNProto)
+	}
+	return protos
+}
+
+// acmeAttempt 域名的证书申请状态
+type acmeAttempt struct {
+	obtaining bool      // 是否正在由 Obtain 在后台申请
+	failures  int       // 连续失败次数
+	retryAt   time.Time // 失败后允许再次申请的时间
+}
+
+// acmeRetryDelay 连续失败 failures 次后的重试间隔
+func acmeRetryDelay(failures int) time.Duration {
+	d := acmeRetryMin
+	for i := 1; i < failures && d < acmeRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, acmeRetryMax)
+}
+
+// Obtain 在后台为域名申请证书，已有手动配置证书、正在申请或最近申请失败的域名跳过
+// 证书签发通常需要数秒，提前申请可以避免首个访问者的握手等待
+func (s *Store) Obtain(hosts []string) {
+	m := s.acmeManager()
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	for _, host := range hosts {
+		if s.Lookup(host) != nil {
+			continue
+		}
+
+		s.acmeMu.Lock()
+		a := s.acmeAttempts[host]
+		if a == nil {
+			a = &acmeAttempt{}
+			s.acmeAttempts[host] = a
+		}
+		skip := a.obtaining || now.Before(a.retryAt)
+		if !skip {
+			a.obtaining = true
+		}
+		s.acmeMu.Unlock()
+		if skip {
+			continue
+		}
+
+		go func(host string) {
+			// 声明支持 ECDSA，与现代客户端使用同一份证书
+			hello := &tls.ClientHelloInfo{
+				ServerName:   host,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			}
+			if _, err := s.acmeCertificate(m, hello); err != nil {
+				log.Printf("[ACME] 申请 %s 的证书失败: %v", host, err)
+			}
+			s.acmeMu.Lock()
+			a.obtaining = false
+			s.acmeMu.Unlock()
+		}(host)
+	}
+}
+
+// acmeCertificate 通过 ACME 获取证书并记录结果
+// 最近申请失败的域名在重试间隔内直接返回错误，不再联系 CA
+func (s *Store) acmeCertificate(m *autocert.Manager, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.acmeMu.Lock()
+	a := s.acmeAttempts[host]
+	if a == nil {
+		a = &acmeAttempt{}
+		s.acmeAttempts[host] = a
+	}
+	if retryAt := a.retryAt; time.Now().Before(retryAt) {
+		s.acmeMu.Unlock()
+		return nil, fmt.Errorf("最近一次申请失败，%s 后重试", retryAt.Format(time.DateTime))
+	}
+	s.acmeMu.Unlock()
+
+	cert, err := m.GetCertificate(hello)
+
+	s.acmeMu.Lock()
+	defer s.acmeMu.Unlock()
+	now := time.Now()
+	switch {
+	case err == nil:
+		a.failures, a.retryAt = 0, time.Time{}
+	case !now.Before(a.retryAt):
+		// 同时进行的握手共用一次申请，只按一次失败计算
+		a.failures++
+		a.retryAt = now.Add(acmeRetryDelay(a.failures))
+	}
+	return cert, err
+}
+
+// isACMEChallenge 判断是否为 TLS-ALPN-01 验证握手
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// acmeCertificates 列出 ACME 缓存目录中已签发的证书
+func (s *Store) acmeCertificates() []Certificate {
+	if s.acme == nil {
+		return nil
+	}
+	files, _ := filepath.Glob(filepath.Join(s.acmeDir, "*"))
+	slices.Sort(files)
+
+	var result []Certificate
+	for _, file := range files {
+		name := filepath.Base(file)
+		// 证书以域名命名（RSA 证书带 +rsa 后缀），跳过账号密钥和验证用的临时文件
+		if strings.Contains(name, "+") && !strings.HasSuffix(name, "+rsa") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		// 缓存文件依次为私钥和证书链
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				break
+			}
+			e := &entry{info: Certificate{ID: name, Source: SourceACME, CertFile: file}}
+			e.setCert(&tls.Certificate{Certificate: [][]byte{block.Bytes}, Leaf: leaf})
+			result = append(result, e.info)
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// newACMEStore 创建启用 ACME 的证书存储，ACME 目录地址指向总是返回错误的测试服务，返回其收到的请求数
+func newACMEStore(t *testing.T, files []KeyPair, allowed ...string) (*Store, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusBadRequest)
+	}))
+	t.Cleanup(ca.Close)
+
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "uploads"), files)
+	err := s.EnableACME(ACMEConfig{
+		Directory: ca.URL,
+		CacheDir:  filepath.Join(dir, "acme"),
+		Allow:     func(host string) bool { return slices.Contains(allowed, host) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &hits
+}
+
+// attemptOf 获取域名的证书申请状态
+func attemptOf(s *Store, host string) acmeAttempt {
+	s.acmeMu.Lock()
+	defer s.acmeMu.Unlock()
+	if a := s.acmeAttempts[host]; a != nil {
+		return *a
+	}
+	return acmeAttempt{}
+}
+
+func TestACMEHostPolicy(t *testing.T) {
+	s, _ := newACMEStore(t, nil, "example.com")
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"Example.COM.", true},
+		{"www.example.com", false},
+		{"other.com", false},
+	}
+	for _, tt := range tests {
+		err := s.acme.HostPolicy(context.Background(), tt.host)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q 是否允许签发应为 %v，实际错误 %v", tt.host, tt.ok, err)
+		}
+	}
+}
+
+func TestACMEObtainSkipsManualAndFailedHosts(t *testing.T) {
+	manual := writeTestCert(t, t.TempDir(), "manual", "manual.example.com")
+	s, hits := newACMEStore(t, []KeyPair{manual}, "manual.example.com", "new.example.com")
+
+	s.Obtain([]string{"manual.example.com", "new.example.com"})
+	if attemptOf(s, "manual.example.com").obtaining {
+		t.Fatal("已有手动配置证书的域名不应申请")
+	}
+	if !attemptOf(s, "new.example.com").obtaining {
+		t.Fatal("没有证书的域名应在后台申请")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for attemptOf(s, "new.example.com").obtaining {
+		if time.Now().After(deadline) {
+			t.Fatal("申请未结束")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a := attemptOf(s, "new.example.com")
+	if a.failures != 1 || time.Until(a.retryAt) < acmeRetryMin-time.Minute {
+		t.Fatalf("申请失败后应推迟重试，实际失败 %d 次，%v 后重试", a.failures, time.Until(a.retryAt))
+	}
+
+	// 规则变更再次触发申请时，重试间隔内不联系 CA
+	before := hits.Load()
+	s.Obtain([]string{"new.example.com"})
+	if attemptOf(s, "new.example.com").obtaining {
+		t.Fatal("重试间隔内不应再次申请")
+	}
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"})
+	if cert == nil || err != nil || certName(cert) != "manual.example.com" {
+		t.Fatalf("重试间隔内握手应使用默认证书，实际 %q %v", certName(cert), err)
+	}
+	if hits.Load() != before {
+		t.Fatalf("重试间隔内不应联系 CA，收到 %d 次请求", hits.Load()-before)
+	}
+}
+
+func TestACMERetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{4, 40 * time.Minute},
+		{5, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := acmeRetryDelay(tt.failures); got != tt.want {
+			t.Errorf("连续失败 %d 次后应等待 %v，实际 %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestACMECertificatesFromCache(t *testing.T) {
+	s, _ := newACMEStore(t, nil)
+	certPEM, keyPEM := newTestCert(t, time.Now().Add(24*time.Hour), "example.com")
+	// autocert 的缓存文件：私钥在前，证书链在后
+	bundle := append(append([]byte{}, keyPEM...), certPEM...)
+	files := map[string][]byte{
+		"example.com":        bundle,
+		"example.com+rsa":    bundle,
+		"acme_account+key":   keyPEM,
+		"token+http-01":      []byte("token.thumbprint"),
+		"broken.example.com": []byte("not a certificate"),
+	}
+	if err := os.MkdirAll(s.acmeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(s.acmeDir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for _, c := range s.List() {
+		if c.Source != SourceACME || len(c.Names) != 1 || c.Names[0] != "example.com" {
+			t.Errorf("证书 %s 的信息不正确: %+v", c.ID, c)
+		}
+		ids = append(ids, c.ID)
+	}
+	if want := []string{"example.com", "example.com+rsa"}; !slices.Equal(ids, want) {
+		t.Fatalf("应列出 %v，实际 %v", want, ids)
+	}
+}
+
+func TestGetCertificateRoutesACMEChallenge(t *testing.T) {
+	manual := writeTestCert(t, t.TempDir(), "manual", "example.com")
+	s, hits := newACMEStore(t, []KeyPair{manual}, "example.com")
+
+	// 普通握手使用手动配置的证书
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{"h2"}})
+	if err != nil || certName(cert) != "example.com" {
+		t.Fatalf("普通握手应使用手动配置的证书，实际 %q %v", certName(cert), err)
+	}
+
+	// TLS-ALPN-01 验证握手交给 ACME 管理器，即使域名已有手动配置的证书；没有进行中的验证时返回错误
+	cert, err = s.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{acme.ALPNProto}})
+	if err == nil || cert != nil {
+		t.Fatalf("没有进行中的验证时不应返回证书，实际 %q", certName(cert))
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("应答验证握手不应联系 CA，收到 %d 次请求", hits.Load())
+	}
+	if !slices.Contains(s.NextProtos(), acme.ALPNProto) {
+		t.Fatalf("启用 ACME 时 ALPN 应包含 %s", acme.ALPNProto)
+	}
+}
@@ -0,0 +1,336 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// 证书来源
+const (
+	SourceFile   = "file"   // 通过环境变量配置的证书文件
+	SourceUpload = "upload" // 通过管理 API 上传，保存在证书目录中
+)
+
+// watchInterval 检查证书文件是否变化的间隔
+const watchInterval = 10 * time.Second
+
+// KeyPair 证书和私钥文件路径
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Certificate 证书信息
+type Certificate struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	Names     []string  `json:"names"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	CertFile  string    `json:"cert_file"`
+	Error     string    `json:"error,omitempty"` // 最近一次加载失败的原因，失败时继续使用上次成功加载的证书
+}
+
+// entry 已加载的证书
+type entry struct {
+	info Certificate
+	pair KeyPair
+	cert *tls.Certificate
+}
+
+// Store 证书存储，按 SNI 选择证书，证书文件变化时自动重新加载
+type Store struct {
+	mu       sync.RWMutex
+	dir      string    // 上传证书的保存目录
+	files    []KeyPair // 配置的证书文件
+	entries  []*entry
+	byName   map[string]*entry // 小写域名（含 *.example.com 形式的通配符）-> 证书
+	fallback *entry            // 客户端未发送 SNI 或没有匹配的证书时使用
+	sig      string            // 证书文件的修改时间签名，用于检测变化
+
+	acme      *autocert.Manager      // ACME 自动签发，未启用时为 nil
+	acmeDir   string                 // ACME 缓存目录
+	acmeAllow func(host string) bool // 允许通过 ACME 签发证书的域名
+
+	acmeMu       sync.Mutex
+	acmeAttempts map[string]*acmeAttempt // 域名 -> 证书申请状态
+}
+
+// NewStore 创建证书存储并加载证书
+func NewStore(dir string, files []KeyPair) *Store {
+	s := &Store{dir: dir, files: files, acmeAttempts: make(map[string]*acmeAttempt)}
+	s.Reload()
+	return s
+}
+
+// Watch 定期检查证书文件，发生变化时重新加载
+func (s *Store) Watch() {
+	go func() {
+		for range time.Tick(watchInterval) {
+			s.mu.RLock()
+			changed := s.signature() != s.sig
+			s.mu.RUnlock()
+			if changed {
+				log.Printf("[Certs] 检测到证书文件变化，重新加载")
+				s.Reload()
+			}
+		}
+	}()
+}
+
+// Reload 重新加载所有证书
+// 单个证书加载失败时记录错误，并继续使用上次成功加载的版本
+func (s *Store) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := make(map[string]*entry, len(s.entries))
+	for _, e := range s.entries {
+		old[e.info.ID] = e
+	}
+
+	var entries []*entry
+	for _, p := range s.files {
+		entries = append(entries, s.load(p.CertFile, SourceFile, p, old))
+	}
+	for _, p := range s.uploads() {
+		id := strings.TrimSuffix(filepath.Base(p.CertFile), ".crt")
+		entries = append(entries, s.load(id, SourceUpload, p, old))
+	}
+
+	s.entries = entries
+	s.sig = s.signature()
+	s.index()
+}
+
+// load 加载单个证书
+func (s *Store) load(id, source string, pair KeyPair, old map[string]*entry) *entry {
+	e := &entry{pair: pair, info: Certificate{ID: id, Source: source, CertFile: pair.CertFile}}
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err == nil {
+		err = e.setCert(&cert)
+	}
+	if err != nil {
+		log.Printf("[Certs] 加载证书 %s 失败: %v", pair.CertFile, err)
+		if prev, ok := old[id]; ok && prev.cert != nil {
+			e.info, e.cert = prev.info, prev.cert
+		}
+		e.info.Error = err.Error()
+	}
+	return e
+}
+
+// setCert 设置证书并解析其中的域名和有效期
+func (e *entry) setCert(cert *tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+
+	names := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses))
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+
+	e.cert = cert
+	e.info.Names = names
+	e.info.Issuer = leaf.Issuer.String()
+	e.info.NotBefore = leaf.NotBefore
+	e.info.NotAfter = leaf.NotAfter
+	return nil
+}
+
+// index 重建域名索引，同一域名有多个证书时使用过期时间最晚的
+func (s *Store) index() {
+	s.byName = make(map[string]*entry)
+	s.fallback = nil
+	for _, e := range s.entries {
+		if e.cert == nil {
+			continue
+		}
+		if s.fallback == nil {
+			s.fallback = e
+		}
+		for _, name := range e.info.Names {
+			if cur, ok := s.byName[name]; !ok || e.info.NotAfter.After(cur.info.NotAfter) {
+				s.byName[name] = e
+			}
+		}
+	}
+}
+
+// uploads 列出证书目录中成对的证书和私钥文件
+func (s *Store) uploads() []KeyPair {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*.crt"))
+	sort.Strings(matches)
+
+	var pairs []KeyPair
+	for _, certFile := range matches {
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+		if _, err := os.Stat(keyFile); err == nil {
+			pairs = append(pairs, KeyPair{CertFile: certFile, KeyFile: keyFile})
+		}
+	}
+	return pairs
+}
+
+// signature 根据所有证书文件的路径、大小和修改时间生成签名
+func (s *Store) signature() string {
+	var sb strings.Builder
+	for _, p := range append(slices.Clone(s.files), s.uploads()...) {
+		for _, path := range []string{p.CertFile, p.KeyFile} {
+			sb.WriteString(path)
+			if fi, err := os.Stat(path); err == nil {
+				fmt.Fprintf(&sb, ":%d:%d", fi.Size(), fi.ModTime().UnixNano())
+			}
+			sb.WriteByte(';')
+		}
+	}
+	return sb.String()
+}
+
+// GetCertificate 按 SNI 选择证书，用于 tls.Config.GetCertificate
+// 依次匹配精确域名、通配符域名，再通过 ACME 获取，都没有时使用默认证书
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m := s.acmeManager()
+	if m != nil && isACMEChallenge(hello) {
+		return m.GetCertificate(hello)
+	}
+	if cert := s.Lookup(hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	if name := strings.ToLower(strings.TrimSuffix(hello.ServerName, ".")); m != nil && s.acmeAllow(name) {
+		cert, err := s.acmeCertificate(m, hello)
+		if err == nil {
+			return cert, nil
+		}
+		log.Printf("[ACME] 获取 %s 的证书失败: %v", name, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.fallback != nil {
+		return s.fallback.cert, nil
+	}
+	return nil, fmt.Errorf("没有可用于 %q 的证书", hello.ServerName)
+}
+
+// Lookup 查找与域名匹配的证书，没有时返回 nil
+func (s *Store) Lookup(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.byName[name]; ok {
+		return e.cert
+	}
+	// 通配符证书只匹配一级子域名
+	if i := strings.IndexByte(name, '.'); i > 0 && net.ParseIP(name) == nil {
+		if e, ok := s.byName["*"+name[i:]]; ok {
+			return e.cert
+		}
+	}
+	return nil
+}
+
+// List 列出所有证书
+func (s *Store) List() []Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Certificate, 0, len(s.entries))
+	for _, e := range s.entries {
+		result = append(result, e.info)
+	}
+	return append(result, s.acmeCertificates()...)
+}
+
+// Add 保存上传的证书和私钥（PEM 格式）并重新加载
+func (s *Store) Add(certPEM, keyPEM []byte) (Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("证书或私钥无效: %w", err)
+	}
+	e := &entry{}
+	if err := e.setCert(&cert); err != nil {
+		return Certificate{}, fmt.Errorf("解析证书失败: %w", err)
+	}
+	if time.Now().After(e.info.NotAfter) {
+		return Certificate{}, errors.New("证书已过期")
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return Certificate{}, err
+	}
+	id := uuid.New().String()
+	base := filepath.Join(s.dir, id)
+	if err := os.WriteFile(base+".key", keyPEM, 0600); err != nil {
+		return Certificate{}, err
+	}
+	if err := os.WriteFile(base+".crt", certPEM, 0644); err != nil {
+		os.Remove(base + ".key")
+		return Certificate{}, err
+	}
+
+	s.Reload()
+	for _, c := range s.List() {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return Certificate{}, errors.New("证书保存后加载失败")
+}
+
+// Remove 删除上传的证书，通过环境变量配置的证书不能删除
+func (s *Store) Remove(id string) error {
+	s.mu.RLock()
+	var target *entry
+	for _, e := range s.entries {
+		if e.info.ID == id {
+			target = e
+		}
+	}
+	s.mu.RUnlock()
+
+	if target == nil {
+		return os.ErrNotExist
+	}
+	if target.info.Source != SourceUpload {
+		return errors.New("只能删除通过 API 上传的证书")
+	}
+	if err := os.Remove(target.pair.CertFile); err != nil {
+		return err
+	}
+	os.Remove(target.pair.KeyFile)
+
+	s.Reload()
+	return nil
+}
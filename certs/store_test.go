@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert 生成自签名证书，返回 PEM 格式的证书和私钥
+func newTestCert(t *testing.T, notAfter time.Time, names ...string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTestCert 生成证书并写入目录，返回文件路径
+func writeTestCert(t *testing.T, dir, name string, names ...string) KeyPair {
+	t.Helper()
+	certPEM, keyPEM := newTestCert(t, time.Now().Add(24*time.Hour), names...)
+	pair := KeyPair{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	if err := os.WriteFile(pair.CertFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pair.KeyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+// certName 证书的第一个域名，证书为 nil 时返回空字符串
+func certName(cert *tls.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	wildcard := writeTestCert(t, dir, "wildcard", "*.example.com")
+	exact := writeTestCert(t, dir, "exact", "www.example.com")
+	s := NewStore(filepath.Join(dir, "uploads"), []KeyPair{wildcard, exact})
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"WWW.Example.COM.", "www.example.com"},
+		{"api.example.com", "*.example.com"},
+		{"a.b.example.com", ""},
+		{"example.com", ""},
+		{"other.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := certName(s.Lookup(tt.serverName)); got != tt.want {
+			t.Errorf("%q 应匹配 %q，实际 %q", tt.serverName, tt.want, got)
+		}
+	}
+}
+
+func TestGetCertificateFallback(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTestCert(t, dir, "first", "a.example.com")
+	second := writeTestCert(t, dir, "second", "b.example.com")
+	s := NewStore(filepath.Join(dir, "uploads"), []KeyPair{first, second})
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"b.example.com", "b.example.com"},
+		// 没有 SNI 或没有匹配的证书时使用第一个证书
+		{"", "a.example.com"},
+		{"other.com", "a.example.com"},
+	}
+	for _, tt := range tests {
+		cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil || certName(cert) != tt.want {
+			t.Errorf("%q 应使用 %q，实际 %q %v", tt.serverName, tt.want, certName(cert), err)
+		}
+	}
+
+	empty := NewStore(filepath.Join(dir, "empty"), nil)
+	if _, err := empty.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Fatal("没有证书时应返回错误")
+	}
+}
+
+func TestReloadKeepsLastGoodCertificate(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeTestCert(t, dir, "site", "www.example.com")
+	s := NewStore(filepath.Join(dir, "uploads"), []KeyPair{pair})
+	before := s.Lookup("www.example.com")
+	if before == nil {
+		t.Fatal("证书应已加载")
+	}
+
+	// 写入损坏的证书后重新加载
+	if err := os.WriteFile(pair.CertFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Reload()
+
+	if after := s.Lookup("www.example.com"); after != before {
+		t.Fatal("加载失败时应继续使用上次成功加载的证书")
+	}
+	list := s.List()
+	if len(list) != 1 || list[0].Error == "" || len(list[0].Names) == 0 {
+		t.Fatalf("证书列表应保留原信息并记录错误: %+v", list)
+	}
+
+	// 证书恢复后清除错误
+	certPEM, keyPEM := newTestCert(t, time.Now().Add(48*time.Hour), "www.example.com")
+	os.WriteFile(pair.CertFile, certPEM, 0644)
+	os.WriteFile(pair.KeyFile, keyPEM, 0600)
+	s.Reload()
+	if after := s.Lookup("www.example.com"); after == nil || after == before {
+		t.Fatal("证书恢复后应加载新证书")
+	}
+	if list := s.List(); list[0].Error != "" {
+		t.Fatalf("证书恢复后不应再有错误: %s", list[0].Error)
+	}
+}
+
+func TestAddValidation(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, nil)
+
+	expiredCert, expiredKey := newTestCert(t, time.Now().Add(-time.Hour), "old.example.com")
+	if _, err := s.Add(expiredCert, expiredKey); err == nil || !strings.Contains(err.Error(), "过期") {
+		t.Fatalf("过期证书应被拒绝: %v", err)
+	}
+
+	certPEM, _ := newTestCert(t, time.Now().Add(time.Hour), "www.example.com")
+	_, otherKey := newTestCert(t, time.Now().Add(time.Hour), "www.example.com")
+	if _, err := s.Add(certPEM, otherKey); err == nil {
+		t.Fatal("私钥与证书不匹配时应被拒绝")
+	}
+	if _, err := s.Add([]byte("garbage"), otherKey); err == nil {
+		t.Fatal("无效的证书应被拒绝")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("校验失败时不应写入文件: %v", files)
+	}
+
+	certPEM, keyPEM := newTestCert(t, time.Now().Add(time.Hour), "www.example.com")
+	info, err := s.Add(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("上传有效证书失败: %v", err)
+	}
+	if info.Source != SourceUpload || s.Lookup("www.example.com") == nil {
+		t.Fatalf("上传的证书应立即生效: %+v", info)
+	}
+	fi, err := os.Stat(filepath.Join(dir, info.ID+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("私钥文件权限应为 0600，实际 %v", fi.Mode().Perm())
+	}
+}
@@ -2,18 +2,149 @@ package config
 
 import (
 	"encoding/json"
+	"log"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// Upstream 上游地址
+type Upstream struct {
+	URL    string `json:"url"`    // 上游地址，如 http://10.0.0.1:8080
+	Weight int    `json:"weight"` // 权重，默认 1
+}
+
+// HealthCheck 健康检查配置
+type HealthCheck struct {
+	Enabled            bool   `json:"enabled"`             // 启用主动探测
+	Passive            bool   `json:"passive"`             // 根据代理请求的 5xx 和连接错误被动判定
+	Path               string `json:"path"`                // 探测路径，默认 /
+	Interval           int    `json:"interval"`            // 探测间隔（秒），默认 10
+	Timeout            int    `json:"timeout"`             // 探测超时（秒），默认 5
+	ExpectedStatus     int    `json:"expected_status"`     // 期望状态码，0 表示任意 2xx/3xx
+	UnhealthyThreshold int    `json:"unhealthy_threshold"` // 连续失败多少次后标记为不健康，默认 3
+	HealthyThreshold   int    `json:"healthy_threshold"`   // 连续成功多少次后恢复，默认 2
+}
+
+// TransportConfig 上游连接配置，时间单位为秒，0 表示使用默认值
+type TransportConfig struct {
+	MaxIdleConns          int  `json:"max_idle_conns"`          // 最大空闲连接数，默认 100
+	MaxIdleConnsPerHost   int  `json:"max_idle_conns_per_host"` // 每个上游主机的最大空闲连接数，默认 32
+	MaxConnsPerHost       int  `json:"max_conns_per_host"`      // 每个上游主机的最大连接数，0 表示不限制
+	IdleConnTimeout       int  `json:"idle_conn_timeout"`       // 空闲连接超时，默认 90
+	KeepAlive             int  `json:"keep_alive"`              // TCP keep-alive 间隔，默认 30
+	DialTimeout           int  `json:"dial_timeout"`            // 建立连接超时，默认 30
+	TLSHandshakeTimeout   int  `json:"tls_handshake_timeout"`   // TLS 握手超时，默认 10
+	ResponseHeaderTimeout int  `json:"response_header_timeout"` // 等待响应头超时，0 表示不限制
+	DisableKeepAlives     bool `json:"disable_keep_alives"`     // 禁用连接复用
+
+	HTTP2 string `json:"http2"` // 上游 HTTP/2：为空时自动（HTTPS 通过 ALPN 协商），h2c 使用明文 HTTP/2（如 gRPC 服务），off 禁用
+}
+
+// UpstreamTLS 连接上游 HTTPS 服务时的 TLS 配置
+// 证书和私钥可以填写文件路径，也可以直接填写 PEM 内容
+type UpstreamTLS struct {
+	CA                 string `json:"ca"`                   // 信任的 CA 证书，设置后只信任这些 CA
+	Cert               string `json:"cert"`                 // 双向 TLS 的客户端证书
+	Key                string `json:"key"`                  // 客户端证书的私钥
+	ServerName         string `json:"server_name"`          // 覆盖 SNI 和证书校验使用的域名
+	MinVersion         string `json:"min_version"`          // 最低 TLS 版本：1.0、1.1、1.2、1.3，为空时使用 Go 的默认值
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // 跳过证书校验，存在中间人风险，仅用于测试
+}
+
+// RewriteOptions 响应内容重写开关
+type RewriteOptions struct {
+	DisableHTML  bool `json:"disable_html"`  // 关闭HTML链接重写（默认开启）
+	RootRelative bool `json:"root_relative"` // 重写根相对地址，如 /about -> /nsmao/about
+	InlineStyle  bool `json:"inline_style"`  // 重写HTML中 style 属性和 <style> 里的 url()
+	CSS          bool `json:"css"`           // 重写 text/css 响应中的 url() 和 @import
+	JS           bool `json:"js"`            // 重写 JavaScript 响应和 <script> 中常见的地址写法
+	ClientShim   bool `json:"client_shim"`   // 向页面注入客户端脚本，重写运行时构造的 fetch、XHR、WebSocket 等地址
+}
+
+// WebSocketConfig WebSocket 连接限制，时间单位为秒，0 表示不限制
+type WebSocketConfig struct {
+	Disabled       bool `json:"disabled"`        // 拒绝 WebSocket 升级请求
+	IdleTimeout    int  `json:"idle_timeout"`    // 双向都没有数据传输时的空闲超时
+	MaxDuration    int  `json:"max_duration"`    // 单个连接的最长持续时间
+	MaxConnections int  `json:"max_connections"` // 该规则同时存在的最大连接数
+}
+
+// StreamingConfig 流式响应配置
+type StreamingConfig struct {
+	FlushInterval int      `json:"flush_interval"` // 刷新间隔（毫秒），0 使用默认策略（事件流和长度未知的响应立即刷新），-1 每次写入后立即刷新
+	ContentTypes  []string `json:"content_types"`  // 额外视为流式响应的内容类型，这些响应不做内容重写
+}
+
+// HTMLInjection 注入到页面中的 HTML 片段
+type HTMLInjection struct {
+	Head string `json:"head"` // 插入到 </head> 之前，如自定义样式
+	Body string `json:"body"` // 插入到 </body> 之前，如横幅、统计脚本
+}
+
+// SecurityHeaders 上游安全响应头的处理方式，取值为 keep（默认）、remove，CSP 额外支持 rewrite
+type SecurityHeaders struct {
+	CSP          string `json:"csp"`           // Content-Security-Policy 及其 Report-Only 版本，rewrite 将指令中的上游域名替换为 'self'
+	FrameOptions string `json:"frame_options"` // X-Frame-Options
+	HSTS         string `json:"hsts"`          // Strict-Transport-Security
+	CrossOrigin  string `json:"cross_origin"`  // Cross-Origin-Opener-Policy、Cross-Origin-Embedder-Policy、Cross-Origin-Resource-Policy
+}
+
+// HeaderRule 请求头或响应头修改规则
+type HeaderRule struct {
+	Action string `json:"action"` // set 覆盖、add 追加、remove 删除
+	Name   string `json:"name"`   // 头名称
+	Value  string `json:"value"`  // 头的值，支持 {client_ip}、{host}、{scheme}、{rule_name}、{request_id} 变量
+}
+
+// Substitution 响应内容替换规则
+type Substitution struct {
+	Find         string   `json:"find"`          // 查找的文本或正则
+	Replace      string   `json:"replace"`       // 替换内容，正则模式下支持 $1 等捕获组
+	Regex        bool     `json:"regex"`         // 是否按正则匹配
+	ContentTypes []string `json:"content_types"` // 生效的内容类型，如 application/json、text/*，为空时对所有文本类型生效
+}
+
 // ProxyRule 代理规则
 type ProxyRule struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`       // 本地路径前缀，如 /nsmao
-	Target    string    `json:"target"`     // 目标地址，如 https://www.nsmao.com
-	Enabled   bool      `json:"enabled"`    // 是否启用
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`     // 本地路径前缀，如 /nsmao
+	Host     string `json:"host"`     // 匹配的域名，如 example.com 或 *.example.com，为空则匹配所有域名
+	Target   string `json:"target"`   // 目标地址，如 https://www.nsmao.com
+	Enabled  bool   `json:"enabled"`  // 是否启用
+	Priority int    `json:"priority"` // 优先级，数值越大越优先
+
+	ForceHTTPS bool `json:"force_https"` // 通过 HTTP 访问时重定向到 HTTPS
+
+	MatchRegex string `json:"match_regex"` // 路径正则，匹配完整请求路径，如 ^/u/(\d+)/(.*)
+	Rewrite    string `json:"rewrite"`     // 上游路径模板，支持捕获组，如 /users/$1/$2
+	KeepPrefix bool   `json:"keep_prefix"` // 转发时保留路径前缀
+
+	CookieNamespace bool           `json:"cookie_namespace"` // 为 Cookie 名称添加规则专属前缀，避免不同规则的 Cookie 互相覆盖
+	Rewriting       RewriteOptions `json:"rewriting"`        // 响应内容重写开关
+	Inject          HTMLInjection  `json:"inject"`           // 注入到页面中的 HTML 片段
+	ExtraOrigins    []string       `json:"extra_origins"`    // 额外代理的源站（如 CDN），挂载在 <前缀>/__host/<域名>/ 之下
+
+	SecurityHeaders SecurityHeaders `json:"security_headers"` // 上游安全响应头的处理方式
+	RequestHeaders  []HeaderRule    `json:"request_headers"`  // 转发到上游前修改请求头，在默认的 X-Forwarded-* 之后执行
+	ResponseHeaders []HeaderRule    `json:"response_headers"` // 返回客户端前修改响应头
+
+	Substitutions []Substitution `json:"substitutions"` // 响应内容替换规则，按顺序执行
+	MaxBodySize   int64          `json:"max_body_size"` // 内容替换时缓冲的最大响应体（字节），超过时原样透传，默认 5MB
+
+	Upstreams   []Upstream `json:"upstreams"`    // 多个上游地址，为空时使用 Target
+	LoadBalance string     `json:"load_balance"` // 负载均衡策略：round_robin、weighted、least_conn、random、ip_hash、header_hash
+	HashHeader  string     `json:"hash_header"`  // header_hash 策略使用的请求头
+
+	HealthCheck HealthCheck     `json:"health_check"` // 上游健康检查
+	Transport   TransportConfig `json:"transport"`    // 上游连接池配置
+	TLS         UpstreamTLS     `json:"tls"`          // 上游 TLS 配置
+	WebSocket   WebSocketConfig `json:"websocket"`    // WebSocket 连接限制
+	Streaming   StreamingConfig `json:"streaming"`    // 流式响应配置
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -25,9 +156,10 @@ type Config struct {
 
 // ConfigManager 配置管理器
 type ConfigManager struct {
-	mu       sync.RWMutex
-	config   Config
-	filePath string
+	mu        sync.RWMutex
+	config    Config
+	filePath  string
+	listeners []func()
 }
 
 var (
@@ -38,18 +170,44 @@ var (
 // GetManager 获取配置管理器单例
 func GetManager() *ConfigManager {
 	once.Do(func() {
-		manager = &ConfigManager{
-			filePath: "data/rules.json",
-			config:   Config{Rules: []ProxyRule{}},
-		}
+		manager = NewManager("data/rules.json")
 		manager.Load()
 	})
 	return manager
 }
 
+// NewManager 创建使用指定规则文件的配置管理器，需调用 Load 加载规则
+func NewManager(filePath string) *ConfigManager {
+	return &ConfigManager{
+		filePath: filePath,
+		config:   Config{Rules: []ProxyRule{}},
+	}
+}
+
+// OnChange 注册规则变更回调，规则加载或修改后调用
+func (m *ConfigManager) OnChange(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// notify 通知规则变更，必须在释放锁之后调用
+func (m *ConfigManager) notify() {
+	m.mu.RLock()
+	listeners := make([]func(), len(m.listeners))
+	copy(listeners, m.listeners)
+	m.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
 // Load 从文件加载配置
 func (m *ConfigManager) Load() error {
 	m.mu.Lock()
+	// defer 按后进先出执行，先释放锁再通知
+	defer m.notify()
 	defer m.mu.Unlock()
 
 	data, err := os.ReadFile(m.filePath)
@@ -61,7 +219,32 @@ func (m *ConfigManager) Load() error {
 		return err
 	}
 
-	return json.Unmarshal(data, &m.config)
+	if err := json.Unmarshal(data, &m.config); err != nil {
+		return err
+	}
+
+	// 手动编辑的规则文件可能缺少 ID 或 ID 重复，重新分配后写回文件
+	if m.assignRuleIDs() {
+		return m.saveWithoutLock()
+	}
+	return nil
+}
+
+// assignRuleIDs 为 ID 为空或重复的规则分配新 ID，返回是否有修改
+func (m *ConfigManager) assignRuleIDs() bool {
+	changed := false
+	seen := make(map[string]bool, len(m.config.Rules))
+	for i := range m.config.Rules {
+		rule := &m.config.Rules[i]
+		if rule.ID == "" || seen[rule.ID] {
+			id := uuid.New().String()
+			log.Printf("[Config] 规则 %s 的 ID %q 为空或重复，已重新分配为 %s", rule.Name, rule.ID, id)
+			rule.ID = id
+			changed = true
+		}
+		seen[rule.ID] = true
+	}
+	return changed
 }
 
 // Save 保存配置到文件
@@ -71,12 +254,17 @@ func (m *ConfigManager) Save() error {
 	return m.saveWithoutLock()
 }
 
+// saveWithoutLock 写入规则文件，规则中可能包含上游 TLS 私钥，文件只对所有者可读写
 func (m *ConfigManager) saveWithoutLock() error {
 	data, err := json.MarshalIndent(m.config, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(m.filePath, data, 0644)
+	if err := os.WriteFile(m.filePath, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile 不会修改已存在文件的权限，旧版本创建的文件需要单独收紧
+	return os.Chmod(m.filePath, 0600)
 }
 
 // GetRules 获取所有规则
@@ -106,6 +294,7 @@ func (m *ConfigManager) GetEnabledRules() []ProxyRule {
 // AddRule 添加规则
 func (m *ConfigManager) AddRule(rule ProxyRule) error {
 	m.mu.Lock()
+	defer m.notify()
 	defer m.mu.Unlock()
 
 	rule.CreatedAt = time.Now()
@@ -118,6 +307,7 @@ func (m *ConfigManager) AddRule(rule ProxyRule) error {
 // UpdateRule 更新规则
 func (m *ConfigManager) UpdateRule(rule ProxyRule) error {
 	m.mu.Lock()
+	defer m.notify()
 	defer m.mu.Unlock()
 
 	for i, r := range m.config.Rules {
@@ -135,6 +325,7 @@ func (m *ConfigManager) UpdateRule(rule ProxyRule) error {
 // DeleteRule 删除规则
 func (m *ConfigManager) DeleteRule(id string) error {
 	m.mu.Lock()
+	defer m.notify()
 	defer m.mu.Unlock()
 
 	for i, r := range m.config.Rules {
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAssignsRuleIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"rules":[{"id":"uuid","name":"a"},{"id":"uuid","name":"b"},{"name":"c"}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(path)
+	if err := m.Load(); err != nil {
+		t.Fatalf("加载规则失败: %v", err)
+	}
+
+	rules := m.GetRules()
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		if r.ID == "" || seen[r.ID] {
+			t.Fatalf("规则 %s 的 ID %q 为空或重复", r.Name, r.ID)
+		}
+		seen[r.ID] = true
+	}
+	if rules[0].ID != "uuid" {
+		t.Fatalf("第一次出现的 ID 应保留，实际 %q", rules[0].ID)
+	}
+
+	// 重新分配的 ID 写回文件，再次加载保持不变
+	reloaded := NewManager(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("重新加载规则失败: %v", err)
+	}
+	for i, r := range reloaded.GetRules() {
+		if r.ID != rules[i].ID {
+			t.Fatalf("规则 %s 的 ID 未写回文件: %q != %q", r.Name, r.ID, rules[i].ID)
+		}
+	}
+}
+
+func TestRulesFilePermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	// 旧版本以 0644 创建的文件保存后也应收紧权限
+	if err := os.WriteFile(path, []byte(`{"rules":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm := NewManager(path)
+	if err := cm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.AddRule(ProxyRule{ID: "a", Name: "a", Path: "/a", Target: "http://127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("规则文件权限应为 0600，实际 %v", fi.Mode().Perm())
+	}
+}
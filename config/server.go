@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ServerConfig 服务级配置，通过环境变量设置
+type ServerConfig struct {
+	TrustedProxies []netip.Prefix // 受信任的前置代理网段，只有来自这些地址的 X-Forwarded-* 和 Forwarded 才会被采信
+
+	ProxyProtocol         bool           // 监听器是否解析 PROXY protocol v1/v2 头
+	ProxyProtocolNetworks []netip.Prefix // 允许发送 PROXY protocol 头的网段，默认与 TrustedProxies 相同
+
+	AdminHost string // 管理面板和 API 所在的域名，为空时在所有域名下提供
+
+	H2C bool // 是否在明文监听端口上接受 HTTP/2（h2c），用于 gRPC 等客户端直连
+
+	HTTPSAddr     string   // HTTPS 监听地址，为空时不启用 HTTPS
+	TLSCertFiles  []string // 证书文件，与 TLSKeyFiles 按顺序一一对应
+	TLSKeyFiles   []string // 私钥文件
+	HTTPSRedirect bool     // 是否将所有 HTTP 请求重定向到 HTTPS
+
+	ACME          bool     // 是否通过 ACME 为规则中的域名自动签发证书
+	ACMEDirectory string   // ACME 目录地址，为空时使用 Let's Encrypt
+	ACMEEmail     string   // ACME 账号邮箱
+	ACMECARoot    string   // 额外信任的 CA 证书文件，用于测试环境的 ACME 服务（如 Pebble）
+	ACMEHosts     []string // 规则之外需要签发证书的域名，如管理面板的域名
+}
+
+// LoadServerConfig 从环境变量读取服务级配置
+func LoadServerConfig() (ServerConfig, error) {
+	var cfg ServerConfig
+
+	trusted, err := ParsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return cfg, fmt.Errorf("TRUSTED_PROXIES 格式错误: %w", err)
+	}
+	cfg.TrustedProxies = trusted
+
+	cfg.ProxyProtocol = parseBool(os.Getenv("PROXY_PROTOCOL"))
+	networks, err := ParsePrefixes(os.Getenv("PROXY_PROTOCOL_NETWORKS"))
+	if err != nil {
+		return cfg, fmt.Errorf("PROXY_PROTOCOL_NETWORKS 格式错误: %w", err)
+	}
+	if len(networks) == 0 {
+		networks = cfg.TrustedProxies
+	}
+	cfg.ProxyProtocolNetworks = networks
+	if cfg.ProxyProtocol && len(networks) == 0 {
+		return cfg, fmt.Errorf("启用 PROXY_PROTOCOL 时必须设置 PROXY_PROTOCOL_NETWORKS 或 TRUSTED_PROXIES")
+	}
+
+	cfg.AdminHost = strings.ToLower(strings.TrimSpace(os.Getenv("ADMIN_HOST")))
+
+	cfg.H2C = parseBool(os.Getenv("H2C"))
+
+	cfg.HTTPSAddr = strings.TrimSpace(os.Getenv("HTTPS_ADDR"))
+	cfg.TLSCertFiles = splitList(os.Getenv("TLS_CERT_FILE"))
+	cfg.TLSKeyFiles = splitList(os.Getenv("TLS_KEY_FILE"))
+	if len(cfg.TLSCertFiles) != len(cfg.TLSKeyFiles) {
+		return cfg, fmt.Errorf("TLS_CERT_FILE 和 TLS_KEY_FILE 的数量必须一致")
+	}
+	if cfg.HTTPSAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.HTTPSAddr); err != nil {
+			return cfg, fmt.Errorf("HTTPS_ADDR 格式错误: %w", err)
+		}
+	}
+	cfg.HTTPSRedirect = parseBool(os.Getenv("HTTPS_REDIRECT"))
+
+	cfg.ACME = parseBool(os.Getenv("ACME"))
+	cfg.ACMEDirectory = strings.TrimSpace(os.Getenv("ACME_DIRECTORY"))
+	cfg.ACMEEmail = strings.TrimSpace(os.Getenv("ACME_EMAIL"))
+	cfg.ACMECARoot = strings.TrimSpace(os.Getenv("ACME_CA_ROOT"))
+	for _, host := range splitList(os.Getenv("ACME_HOSTS")) {
+		cfg.ACMEHosts = append(cfg.ACMEHosts, strings.ToLower(host))
+	}
+	if cfg.ACME && cfg.HTTPSAddr == "" {
+		return cfg, fmt.Errorf("启用 ACME 时必须设置 HTTPS_ADDR")
+	}
+
+	return cfg, nil
+}
+
+// parseBool 解析布尔类型的环境变量，支持 1、true、yes、on
+func parseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParsePrefixes 解析逗号分隔的网段列表，单个 IP 视为 /32 或 /128
+func ParsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -2,4 +2,12 @@ module go_proxy_every
 
 go 1.23.2
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.38.0
+)
+
+require golang.org/x/text v0.23.0 // indirect
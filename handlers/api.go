@@ -3,29 +3,43 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go_proxy_every/auth"
+	"go_proxy_every/certs"
 	"go_proxy_every/config"
+	"go_proxy_every/proxy"
 	"image"
 	"image/color"
 	"image/png"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/net/http/httpguts"
 )
 
 // APIHandler API处理器
 type APIHandler struct {
 	configManager *config.ConfigManager
 	authManager   *auth.AuthManager
+	proxyManager  *proxy.ProxyManager
+	certStore     *certs.Store
 }
 
 // NewAPIHandler 创建API处理器
-func NewAPIHandler(cm *config.ConfigManager) *APIHandler {
+func NewAPIHandler(cm *config.ConfigManager, pm *proxy.ProxyManager, cs *certs.Store) *APIHandler {
 	return &APIHandler{
 		configManager: cm,
 		authManager:   auth.GetAuthManager(),
+		proxyManager:  pm,
+		certStore:     cs,
 	}
 }
 
@@ -330,15 +344,278 @@ func (h *APIHandler) ListRules(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rules := h.configManager.GetRules()
+	for i := range rules {
+		rules[i] = redactRule(rules[i])
+	}
 	success(w, rules)
 }
 
+// redactRule 隐藏规则中内联的上游 TLS 私钥，文件路径保持可见
+func redactRule(rule config.ProxyRule) config.ProxyRule {
+	if strings.HasPrefix(strings.TrimSpace(rule.TLS.Key), "-----BEGIN") {
+		rule.TLS.Key = ""
+	}
+	return rule
+}
+
 // CreateRuleRequest 创建规则请求
 type CreateRuleRequest struct {
-	Name    string `json:"name"`
-	Path    string `json:"path"`
-	Target  string `json:"target"`
-	Enabled bool   `json:"enabled"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Host     string `json:"host"`
+	Target   string `json:"target"`
+	Enabled  bool   `json:"enabled"`
+	Priority int    `json:"priority"`
+
+	ForceHTTPS bool `json:"force_https"`
+
+	MatchRegex string `json:"match_regex"`
+	Rewrite    string `json:"rewrite"`
+	KeepPrefix bool   `json:"keep_prefix"`
+
+	CookieNamespace bool                  `json:"cookie_namespace"`
+	Rewriting       config.RewriteOptions `json:"rewriting"`
+	Inject          config.HTMLInjection  `json:"inject"`
+	ExtraOrigins    []string              `json:"extra_origins"`
+
+	SecurityHeaders config.SecurityHeaders `json:"security_headers"`
+	RequestHeaders  []config.HeaderRule    `json:"request_headers"`
+	ResponseHeaders []config.HeaderRule    `json:"response_headers"`
+
+	Substitutions []config.Substitution `json:"substitutions"`
+	MaxBodySize   int64                 `json:"max_body_size"`
+
+	Upstreams   []config.Upstream `json:"upstreams"`
+	LoadBalance string            `json:"load_balance"`
+	HashHeader  string            `json:"hash_header"`
+
+	HealthCheck config.HealthCheck     `json:"health_check"`
+	Transport   config.TransportConfig `json:"transport"`
+	TLS         config.UpstreamTLS     `json:"tls"`
+	WebSocket   config.WebSocketConfig `json:"websocket"`
+	Streaming   config.StreamingConfig `json:"streaming"`
+}
+
+// toRule 转换为代理规则
+func (req CreateRuleRequest) toRule(id string) config.ProxyRule {
+	return config.ProxyRule{
+		ID:       id,
+		Name:     req.Name,
+		Path:     req.Path,
+		Host:     strings.ToLower(strings.TrimSpace(req.Host)),
+		Target:   req.Target,
+		Enabled:  req.Enabled,
+		Priority: req.Priority,
+
+		ForceHTTPS: req.ForceHTTPS,
+
+		MatchRegex: strings.TrimSpace(req.MatchRegex),
+		Rewrite:    strings.TrimSpace(req.Rewrite),
+		KeepPrefix: req.KeepPrefix,
+
+		CookieNamespace: req.CookieNamespace,
+		Rewriting:       req.Rewriting,
+		Inject:          req.Inject,
+		ExtraOrigins:    req.ExtraOrigins,
+
+		SecurityHeaders: req.SecurityHeaders,
+		RequestHeaders:  req.RequestHeaders,
+		ResponseHeaders: req.ResponseHeaders,
+
+		Substitutions: req.Substitutions,
+		MaxBodySize:   req.MaxBodySize,
+
+		Upstreams:   req.Upstreams,
+		LoadBalance: req.LoadBalance,
+		HashHeader:  strings.TrimSpace(req.HashHeader),
+
+		HealthCheck: req.HealthCheck,
+		Transport:   req.Transport,
+		TLS:         req.TLS,
+		WebSocket:   req.WebSocket,
+		Streaming:   req.Streaming,
+	}
+}
+
+// validateRule 校验规则，返回错误信息，校验通过返回空字符串
+func (h *APIHandler) validateRule(rule config.ProxyRule) string {
+	if rule.Name == "" || (rule.Target == "" && len(rule.Upstreams) == 0) {
+		return "名称和目标地址不能为空"
+	}
+
+	if rule.Target != "" && !isValidUpstreamURL(rule.Target) {
+		return "目标地址格式错误"
+	}
+
+	for _, u := range rule.Upstreams {
+		if !isValidUpstreamURL(u.URL) {
+			return "上游地址格式错误: " + u.URL
+		}
+		if u.Weight < 0 || u.Weight > proxy.MaxWeight {
+			return fmt.Sprintf("上游权重必须在 0 到 %d 之间", proxy.MaxWeight)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, o := range rule.ExtraOrigins {
+		u, err := url.Parse(o)
+		if err != nil || !isValidUpstreamURL(o) || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+			return "额外源站格式错误（只能包含协议和域名）: " + o
+		}
+		host := strings.ToLower(u.Host)
+		if seen[host] {
+			return "额外源站重复: " + o
+		}
+		seen[host] = true
+	}
+
+	if rule.LoadBalance != "" && !slices.Contains(proxy.Strategies, rule.LoadBalance) {
+		return "不支持的负载均衡策略: " + rule.LoadBalance
+	}
+
+	if rule.LoadBalance == proxy.StrategyHeaderHash && rule.HashHeader == "" {
+		return "header_hash 策略需要指定请求头"
+	}
+
+	// 只设置路径正则的规则由正则决定匹配范围
+	if rule.Path == "" && rule.Host == "" && rule.MatchRegex == "" {
+		return "路径、域名和路径正则不能同时为空"
+	}
+
+	if rule.Host != "" {
+		host := strings.TrimPrefix(rule.Host, "*.")
+		if host == "" || strings.ContainsAny(host, "*/: ") {
+			return "域名格式错误"
+		}
+	}
+
+	if rule.MatchRegex != "" {
+		if _, err := regexp.Compile(rule.MatchRegex); err != nil {
+			return "路径正则无效: " + err.Error()
+		}
+	}
+
+	if rule.Rewrite != "" && rule.MatchRegex == "" {
+		return "路径重写需要同时设置路径正则"
+	}
+
+	sh := rule.SecurityHeaders
+	if !slices.Contains([]string{"", proxy.PolicyKeep, proxy.PolicyRemove, proxy.PolicyRewrite}, sh.CSP) {
+		return "不支持的 CSP 处理方式: " + sh.CSP
+	}
+	for _, p := range []string{sh.FrameOptions, sh.HSTS, sh.CrossOrigin} {
+		if !slices.Contains([]string{"", proxy.PolicyKeep, proxy.PolicyRemove}, p) {
+			return "不支持的安全响应头处理方式: " + p
+		}
+	}
+
+	if msg := validateHeaderRules(rule.RequestHeaders, "请求头"); msg != "" {
+		return msg
+	}
+	if msg := validateHeaderRules(rule.ResponseHeaders, "响应头"); msg != "" {
+		return msg
+	}
+
+	for i, s := range rule.Substitutions {
+		if s.Find == "" {
+			return fmt.Sprintf("第 %d 条内容替换的查找内容不能为空", i+1)
+		}
+		if s.Regex {
+			if _, err := regexp.Compile(s.Find); err != nil {
+				return fmt.Sprintf("第 %d 条内容替换的正则无效: %v", i+1, err)
+			}
+		}
+		for _, ct := range s.ContentTypes {
+			if ct = strings.TrimSpace(ct); ct != "" && !strings.Contains(ct, "/") {
+				return fmt.Sprintf("第 %d 条内容替换的内容类型格式错误: %s", i+1, ct)
+			}
+		}
+	}
+	if rule.MaxBodySize < 0 {
+		return "最大响应体大小不能为负数"
+	}
+
+	hc := rule.HealthCheck
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.UnhealthyThreshold < 0 || hc.HealthyThreshold < 0 {
+		return "健康检查参数不能为负数"
+	}
+	if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+		return "健康检查期望状态码无效"
+	}
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return "健康检查路径必须以 / 开头"
+	}
+
+	ws := rule.WebSocket
+	if ws.IdleTimeout < 0 || ws.MaxDuration < 0 || ws.MaxConnections < 0 {
+		return "WebSocket 限制参数不能为负数"
+	}
+
+	if rule.Streaming.FlushInterval < -1 {
+		return "刷新间隔只能为 -1、0 或正数"
+	}
+	for _, ct := range rule.Streaming.ContentTypes {
+		if !strings.Contains(ct, "/") {
+			return "流式内容类型格式错误: " + ct
+		}
+	}
+
+	tc := rule.Transport
+	for _, v := range []int{tc.MaxIdleConns, tc.MaxIdleConnsPerHost, tc.MaxConnsPerHost, tc.IdleConnTimeout,
+		tc.KeepAlive, tc.DialTimeout, tc.TLSHandshakeTimeout, tc.ResponseHeaderTimeout} {
+		if v < 0 {
+			return "连接池参数不能为负数"
+		}
+	}
+	if !slices.Contains(proxy.HTTP2Modes, tc.HTTP2) {
+		return "上游 HTTP/2 模式无效"
+	}
+	if err := proxy.ValidateUpstreamTLS(rule.TLS); err != nil {
+		return "上游 TLS 配置无效: " + err.Error()
+	}
+
+	// 域名+路径+路径正则 唯一
+	for _, existing := range h.configManager.GetRules() {
+		if existing.ID == rule.ID {
+			continue
+		}
+		if strings.EqualFold(existing.Host, rule.Host) && rulePathKey(existing.Path) == rulePathKey(rule.Path) &&
+			existing.MatchRegex == rule.MatchRegex {
+			return "相同域名下路径已存在"
+		}
+	}
+
+	return ""
+}
+
+// validateHeaderRules 校验头修改规则
+func validateHeaderRules(rules []config.HeaderRule, kind string) string {
+	for i, hr := range rules {
+		if !slices.Contains(proxy.HeaderActions, strings.ToLower(hr.Action)) {
+			return fmt.Sprintf("第 %d 条%s修改的操作无效: %s", i+1, kind, hr.Action)
+		}
+		if !httpguts.ValidHeaderFieldName(strings.TrimSpace(hr.Name)) {
+			return fmt.Sprintf("第 %d 条%s修改的名称无效: %s", i+1, kind, hr.Name)
+		}
+		if !httpguts.ValidHeaderFieldValue(hr.Value) {
+			return fmt.Sprintf("第 %d 条%s修改的值包含非法字符", i+1, kind)
+		}
+	}
+	return ""
+}
+
+// isValidUpstreamURL 校验上游地址，必须包含协议和主机
+func isValidUpstreamURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// rulePathKey 规范化路径用于比较
+func rulePathKey(path string) string {
+	return strings.Trim(path, "/")
 }
 
 // CreateRule 创建规则
@@ -354,44 +631,26 @@ func (h *APIHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" || req.Path == "" || req.Target == "" {
-		fail(w, http.StatusBadRequest, "名称、路径和目标地址不能为空")
+	rule := req.toRule(uuid.New().String())
+	if msg := h.validateRule(rule); msg != "" {
+		fail(w, http.StatusBadRequest, msg)
 		return
 	}
-
-	existingRules := h.configManager.GetRules()
-	for _, rule := range existingRules {
-		if rule.Path == req.Path {
-			fail(w, http.StatusBadRequest, "路径已存在")
-			return
-		}
-	}
-
-	rule := config.ProxyRule{
-		ID:        uuid.New().String(),
-		Name:      req.Name,
-		Path:      req.Path,
-		Target:    req.Target,
-		Enabled:   req.Enabled,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
 
 	if err := h.configManager.AddRule(rule); err != nil {
 		fail(w, http.StatusInternalServerError, "保存规则失败")
 		return
 	}
 
-	success(w, rule)
+	success(w, redactRule(rule))
 }
 
 // UpdateRuleRequest 更新规则请求
 type UpdateRuleRequest struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Path    string `json:"path"`
-	Target  string `json:"target"`
-	Enabled bool   `json:"enabled"`
+	ID string `json:"id"`
+	CreateRuleRequest
 }
 
 // UpdateRule 更新规则
@@ -412,12 +671,19 @@ func (h *APIHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rule := config.ProxyRule{
-		ID:      req.ID,
-		Name:    req.Name,
-		Path:    req.Path,
-		Target:  req.Target,
-		Enabled: req.Enabled,
+	rule := req.toRule(req.ID)
+	// 规则列表不返回内联私钥，设置了客户端证书而私钥留空时沿用已保存的私钥
+	if strings.TrimSpace(rule.TLS.Key) == "" && strings.TrimSpace(rule.TLS.Cert) != "" {
+		for _, existing := range h.configManager.GetRules() {
+			if existing.ID == rule.ID {
+				rule.TLS.Key = existing.TLS.Key
+				break
+			}
+		}
+	}
+	if msg := h.validateRule(rule); msg != "" {
+		fail(w, http.StatusBadRequest, msg)
+		return
 	}
 
 	if err := h.configManager.UpdateRule(rule); err != nil {
@@ -425,7 +691,7 @@ func (h *APIHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	success(w, rule)
+	success(w, redactRule(rule))
 }
 
 // DeleteRuleRequest 删除规则请求
@@ -483,10 +749,99 @@ func (h *APIHandler) ToggleRule(w http.ResponseWriter, r *http.Request) {
 				fail(w, http.StatusInternalServerError, "切换状态失败")
 				return
 			}
-			success(w, rule)
+			success(w, redactRule(rule))
 			return
 		}
 	}
 
 	fail(w, http.StatusNotFound, "规则不存在")
 }
+
+// GetHealth 获取上游健康状态
+func (h *APIHandler) GetHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fail(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	success(w, h.proxyManager.Health())
+}
+
+// GetWebSockets 获取各规则的 WebSocket 连接统计
+func (h *APIHandler) GetWebSockets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fail(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	success(w, h.proxyManager.WebSocketStats())
+}
+
+// ListCerts 列出 HTTPS 证书
+func (h *APIHandler) ListCerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fail(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	success(w, h.certStore.List())
+}
+
+// UploadCertRequest 上传证书请求，证书和私钥均为 PEM 格式
+type UploadCertRequest struct {
+	Cert string `json:"cert"` // 证书，可包含中间证书
+	Key  string `json:"key"`
+}
+
+// UploadCert 上传 HTTPS 证书
+func (h *APIHandler) UploadCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fail(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	var req UploadCertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+	if strings.TrimSpace(req.Cert) == "" || strings.TrimSpace(req.Key) == "" {
+		fail(w, http.StatusBadRequest, "证书和私钥不能为空")
+		return
+	}
+
+	cert, err := h.certStore.Add([]byte(req.Cert), []byte(req.Key))
+	if err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	success(w, cert)
+}
+
+// DeleteCert 删除上传的 HTTPS 证书
+func (h *APIHandler) DeleteCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		fail(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+
+	if err := h.certStore.Remove(req.ID); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fail(w, http.StatusNotFound, "证书不存在")
+			return
+		}
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	success(w, nil)
+}
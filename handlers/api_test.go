@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"go_proxy_every/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHandler 创建使用临时规则文件的 API 处理器
+func newTestHandler(t *testing.T) *APIHandler {
+	t.Helper()
+	cm := config.NewManager(filepath.Join(t.TempDir(), "rules.json"))
+	if err := cm.Load(); err != nil {
+		t.Fatalf("加载规则失败: %v", err)
+	}
+	return &APIHandler{configManager: cm}
+}
+
+// createRule 通过 API 创建规则，返回状态码和响应
+func createRule(t *testing.T, h *APIHandler, body string) (int, Response) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/rules", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.CreateRule(rec, req)
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestCreateRuleRegexOnly(t *testing.T) {
+	h := newTestHandler(t)
+
+	code, resp := createRule(t, h, `{"name":"users","target":"http://127.0.0.1:8080","enabled":true,
+		"match_regex":"^/u/(\\d+)/(.*)","rewrite":"/users/$1/$2"}`)
+	if code != http.StatusOK {
+		t.Fatalf("只设置路径正则的规则应创建成功，实际 %d: %s", code, resp.Message)
+	}
+
+	code, _ = createRule(t, h, `{"name":"empty","target":"http://127.0.0.1:8080","enabled":true}`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("路径、域名和路径正则都为空时应拒绝，实际 %d", code)
+	}
+}
+
+func TestCreateRuleUniqueIncludesRegex(t *testing.T) {
+	h := newTestHandler(t)
+
+	rules := []string{
+		`{"name":"a","host":"example.com","path":"api","target":"http://127.0.0.1:8080","match_regex":"^/api/v1/"}`,
+		`{"name":"b","host":"example.com","path":"api","target":"http://127.0.0.1:8080","match_regex":"^/api/v2/"}`,
+		`{"name":"c","host":"example.com","path":"api","target":"http://127.0.0.1:8080"}`,
+	}
+	for _, body := range rules {
+		if code, resp := createRule(t, h, body); code != http.StatusOK {
+			t.Fatalf("同一域名和前缀下不同路径正则的规则应创建成功，实际 %d: %s", code, resp.Message)
+		}
+	}
+
+	code, resp := createRule(t, h, `{"name":"d","host":"example.com","path":"/api/","target":"http://127.0.0.1:8080","match_regex":"^/api/v1/"}`)
+	if code != http.StatusBadRequest || resp.Message != "相同域名下路径已存在" {
+		t.Fatalf("域名、路径和路径正则都相同时应拒绝，实际 %d: %s", code, resp.Message)
+	}
+}
+
+// newClientCert 生成 PEM 格式的自签名客户端证书和私钥
+func newClientCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// listRules 通过 API 获取规则列表
+func listRules(t *testing.T, h *APIHandler) []config.ProxyRule {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ListRules(rec, httptest.NewRequest(http.MethodGet, "/api/rules", nil))
+
+	var resp struct {
+		Data []config.ProxyRule `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	return resp.Data
+}
+
+func TestInlineTLSKeyRedacted(t *testing.T) {
+	h := newTestHandler(t)
+	certPEM, keyPEM := newClientCert(t)
+
+	body, _ := json.Marshal(map[string]any{
+		"name": "mtls", "path": "/mtls", "target": "https://127.0.0.1:8443", "enabled": true,
+		"tls": map[string]string{"cert": certPEM, "key": keyPEM},
+	})
+	code, resp := createRule(t, h, string(body))
+	if code != http.StatusOK {
+		t.Fatalf("创建规则失败: %d %s", code, resp.Message)
+	}
+	if strings.Contains(mustJSON(t, resp.Data), "PRIVATE KEY") {
+		t.Fatal("创建规则的响应不应包含私钥")
+	}
+
+	rules := listRules(t, h)
+	if len(rules) != 1 || rules[0].TLS.Key != "" || rules[0].TLS.Cert == "" {
+		t.Fatalf("规则列表应隐藏内联私钥并保留证书: %+v", rules)
+	}
+
+	// 编辑时按列表中的内容原样提交，私钥为空时沿用已保存的私钥
+	rule := rules[0]
+	rule.Name = "mtls-renamed"
+	req := httptest.NewRequest(http.MethodPut, "/api/rules", strings.NewReader(mustJSON(t, rule)))
+	rec := httptest.NewRecorder()
+	h.UpdateRule(rec, req)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "PRIVATE KEY") {
+		t.Fatalf("更新规则失败或响应包含私钥: %d %s", rec.Code, rec.Body.String())
+	}
+	stored := h.configManager.GetRules()[0]
+	if stored.Name != "mtls-renamed" || stored.TLS.Key != keyPEM {
+		t.Fatalf("更新后应保留已保存的私钥: %q", stored.TLS.Key)
+	}
+
+	// 私钥以文件路径填写时原样返回
+	if got := redactRule(config.ProxyRule{TLS: config.UpstreamTLS{Key: "/etc/ssl/client.key"}}).TLS.Key; got != "/etc/ssl/client.key" {
+		t.Fatalf("私钥文件路径不应被隐藏，实际 %q", got)
+	}
+}
+
+// mustJSON 序列化为 JSON 字符串
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,237 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout 读取 PROXY protocol 头的超时时间
+const headerTimeout = 5 * time.Second
+
+// v1MaxLength PROXY protocol v1 头的最大长度（含结尾 CRLF）
+const v1MaxLength = 107
+
+// v2Signature PROXY protocol v2 头的固定签名
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener 支持 HAProxy PROXY protocol v1/v2 的监听器
+// 只解析来自受信任网段的连接，其他连接按普通连接处理，避免客户端伪造来源地址
+type ProxyProtocolListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+// NewProxyProtocolListener 包装监听器以支持 PROXY protocol
+func NewProxyProtocolListener(inner net.Listener, trusted []netip.Prefix) *ProxyProtocolListener {
+	return &ProxyProtocolListener{Listener: inner, trusted: trusted}
+}
+
+// Accept 接受连接，来自受信任网段的连接在首次读取时解析 PROXY protocol 头
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, br: bufio.NewReader(c)}, nil
+}
+
+// isTrusted 判断连接来源是否属于受信任网段
+func (l *ProxyProtocolListener) isTrusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn 带 PROXY protocol 头的连接
+// 头在首次调用 Read 或 RemoteAddr 时解析，不阻塞 Accept
+type proxyConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+// init 解析 PROXY protocol 头，没有该头时按普通连接处理
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		c.remote, c.local, c.err = readHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("[ProxyProtocol] 来自 %s 的头无效: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+// Read 读取连接数据，头无效时返回错误
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr 返回 PROXY protocol 头中的客户端地址
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr 返回 PROXY protocol 头中的目标地址
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader 读取 PROXY protocol 头，返回来源和目标地址
+// 连接不以 PROXY protocol 头开始时返回 nil 地址，数据保留在缓冲区中
+func readHeader(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, nil
+	}
+	switch first[0] {
+	case 'P':
+		if sig, err := br.Peek(6); err == nil && string(sig) == "PROXY " {
+			return readV1(br)
+		}
+	case '\r':
+		if sig, err := br.Peek(len(v2Signature)); err == nil && bytes.Equal(sig, v2Signature) {
+			return readV2(br)
+		}
+	}
+	return nil, nil, nil
+}
+
+// readV1 解析文本格式的 v1 头，如 PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func readV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 头过长或缺少 CRLF")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// 来源未知，使用连接本身的地址
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("v1 头格式错误: %q", line)
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+// parseV1Addr 解析 v1 头中的地址和端口
+func parseV1Addr(ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("v1 头地址无效: %s", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("v1 头端口无效: %s", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 解析二进制格式的 v2 头
+func readV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("不支持的 v2 版本: %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL 命令用于负载均衡器自身的健康检查，使用连接本身的地址
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, fmt.Errorf("不支持的 v2 命令: %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, nil, errors.New("v2 IPv4 地址长度不足")
+		}
+		src := netip.AddrFrom4([4]byte(payload[0:4]))
+		dst := netip.AddrFrom4([4]byte(payload[4:8]))
+		return v2Addr(src, payload[8:10]), v2Addr(dst, payload[10:12]), nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, nil, errors.New("v2 IPv6 地址长度不足")
+		}
+		src := netip.AddrFrom16([16]byte(payload[0:16]))
+		dst := netip.AddrFrom16([16]byte(payload[16:32]))
+		return v2Addr(src, payload[32:34]), v2Addr(dst, payload[34:36]), nil
+	}
+	// UNSPEC、UDP、Unix 套接字等，使用连接本身的地址
+	return nil, nil, nil
+}
+
+// v2Addr 由地址和大端序端口构造 TCP 地址
+func v2Addr(ip netip.Addr, port []byte) net.Addr {
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), binary.BigEndian.Uint16(port)))
+}
@@ -0,0 +1,180 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// v2Header 构造 v2 头，command 为 0（LOCAL）或 1（PROXY）
+func v2Header(command, family byte, payload []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|command, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(payload)))
+	return append(h, payload...)
+}
+
+// v2Payload 构造 v2 地址部分：来源地址、目标地址、来源端口、目标端口
+func v2Payload(src, dst string, srcPort, dstPort uint16) []byte {
+	var p []byte
+	p = append(p, netip.MustParseAddr(src).AsSlice()...)
+	p = append(p, netip.MustParseAddr(dst).AsSlice()...)
+	p = binary.BigEndian.AppendUint16(p, srcPort)
+	return binary.BigEndian.AppendUint16(p, dstPort)
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		src      string
+		dst      string
+		wantErr  bool
+		noHeader bool // 不是 PROXY protocol 头，数据应全部保留
+	}{
+		{name: "v1 TCP4", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), src: "192.0.2.1:56324", dst: "198.51.100.1:443"},
+		{name: "v1 TCP6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 UNKNOWN 带地址", input: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")},
+		{name: "v1 缺少 CRLF", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), wantErr: true},
+		{name: "v1 过长", input: []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLength) + "\r\n"), wantErr: true},
+		{name: "v1 连接提前关闭", input: []byte("PROXY TCP4 192.0.2.1"), wantErr: true},
+		{name: "v1 协议错误", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 地址错误", input: []byte("PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 端口错误", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n"), wantErr: true},
+		{name: "v2 IPv4", input: v2Header(1, 0x11, v2Payload("192.0.2.1", "198.51.100.1", 56324, 443)), src: "192.0.2.1:56324", dst: "198.51.100.1:443"},
+		{name: "v2 IPv6", input: v2Header(1, 0x21, v2Payload("2001:db8::1", "2001:db8::2", 56324, 443)), src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v2 带 TLV", input: v2Header(1, 0x11, append(v2Payload("192.0.2.1", "198.51.100.1", 1, 2), 0x04, 0, 1, 'x')), src: "192.0.2.1:1", dst: "198.51.100.1:2"},
+		{name: "v2 LOCAL", input: v2Header(0, 0x11, v2Payload("192.0.2.1", "198.51.100.1", 1, 2))},
+		{name: "v2 UNSPEC", input: v2Header(1, 0x00, nil)},
+		{name: "v2 负载被截断", input: v2Header(1, 0x11, v2Payload("192.0.2.1", "198.51.100.1", 1, 2))[:len(v2Signature)+4+6], wantErr: true},
+		{name: "v2 头被截断", input: v2Header(1, 0x11, nil)[:len(v2Signature)+1], wantErr: true},
+		{name: "v2 地址长度不足", input: v2Header(1, 0x11, []byte{192, 0, 2, 1}), wantErr: true},
+		{name: "v2 命令错误", input: v2Header(2, 0x11, v2Payload("192.0.2.1", "198.51.100.1", 1, 2)), wantErr: true},
+		{name: "没有头", input: []byte("GET / HTTP/1.1\r\n\r\n"), noHeader: true},
+		{name: "以 P 开头的普通请求", input: []byte("POST / HTTP/1.1\r\n\r\n"), noHeader: true},
+		{name: "以 CR 开头的数据", input: []byte("\r\n\r\nhello"), noHeader: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 头之后的数据应原样保留
+			br := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.input), strings.NewReader("DATA")))
+			src, dst, err := readHeader(br)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("应返回错误，实际 %v %v", src, dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if got := addrString(src); got != tt.src {
+				t.Errorf("来源地址应为 %q，实际 %q", tt.src, got)
+			}
+			if got := addrString(dst); got != tt.dst {
+				t.Errorf("目标地址应为 %q，实际 %q", tt.dst, got)
+			}
+
+			rest, _ := io.ReadAll(br)
+			want := "DATA"
+			if tt.noHeader {
+				want = string(tt.input) + "DATA"
+			}
+			if string(rest) != want {
+				t.Errorf("头之后的数据应为 %q，实际 %q", want, rest)
+			}
+		})
+	}
+}
+
+// addrString 地址的字符串形式，nil 时为空字符串
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// acceptOne 通过监听器建立一个连接，客户端发送 data 后返回服务端的连接
+func acceptOne(t *testing.T, trusted []netip.Prefix, data []byte) net.Conn {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewProxyProtocolListener(inner, trusted)
+	t.Cleanup(func() { ln.Close() })
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+
+	tests := []struct {
+		name    string
+		trusted []netip.Prefix
+		data    string
+		remote  string // 为空时应为连接本身的地址
+		read    string
+	}{
+		{"受信任的代理发送头", loopback, header + "hello", "192.0.2.1:56324", "hello"},
+		{"受信任的代理没有发送头", loopback, "hello", "", "hello"},
+		{"不受信任的来源发送头", nil, header + "hello", "", header + "hello"},
+		{"不在受信任网段内", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, header + "hello", "", header + "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := acceptOne(t, tt.trusted, []byte(tt.data))
+
+			remote := conn.RemoteAddr().String()
+			if tt.remote != "" && remote != tt.remote {
+				t.Fatalf("来源地址应为 %s，实际 %s", tt.remote, remote)
+			}
+			if tt.remote == "" && !strings.HasPrefix(remote, "127.0.0.1:") {
+				t.Fatalf("应使用连接本身的地址，实际 %s", remote)
+			}
+
+			buf := make([]byte, len(tt.read))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatalf("读取失败: %v", err)
+			}
+			if string(buf) != tt.read {
+				t.Fatalf("应读取到 %q，实际 %q", tt.read, buf)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListenerInvalidHeader(t *testing.T) {
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	conn := acceptOne(t, loopback, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("头无效时读取应返回错误")
+	}
+	if remote := conn.RemoteAddr().String(); !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Fatalf("头无效时应使用连接本身的地址，实际 %s", remote)
+	}
+}
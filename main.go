@@ -1,32 +1,74 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
 	"go_proxy_every/auth"
+	"go_proxy_every/certs"
 	"go_proxy_every/config"
 	"go_proxy_every/handlers"
+	"go_proxy_every/listener"
 	"go_proxy_every/proxy"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
-	"strings"
+	"slices"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
 func main() {
+	// 读取服务级配置（环境变量）
+	serverConfig, err := config.LoadServerConfig()
+	if err != nil {
+		log.Fatal("服务配置错误: ", err)
+	}
+
 	// 初始化配置管理器
 	configManager := config.GetManager()
 
 	// 初始化认证管理器
 	_ = auth.GetAuthManager()
 
-	// 创建API处理器
-	apiHandler := handlers.NewAPIHandler(configManager)
-
 	// 创建代理管理器
 	proxyManager := proxy.NewProxyManager(configManager)
+	proxyManager.SetTrustedProxies(serverConfig.TrustedProxies)
+
+	// 加载 HTTPS 证书：环境变量指定的证书文件和通过 API 上传到 data/certs 的证书
+	var certFiles []certs.KeyPair
+	for i, certFile := range serverConfig.TLSCertFiles {
+		certFiles = append(certFiles, certs.KeyPair{CertFile: certFile, KeyFile: serverConfig.TLSKeyFiles[i]})
+	}
+	certStore := certs.NewStore("data/certs", certFiles)
+	certStore.Watch()
+
+	// 通过 ACME 为规则中配置的域名自动签发证书，账号和证书保存在 data/acme
+	if serverConfig.ACME {
+		err := certStore.EnableACME(certs.ACMEConfig{
+			Directory: serverConfig.ACMEDirectory,
+			Email:     serverConfig.ACMEEmail,
+			CARoot:    serverConfig.ACMECARoot,
+			CacheDir:  "data/acme",
+			Allow: func(host string) bool {
+				return proxyManager.HasHost(host) || slices.Contains(serverConfig.ACMEHosts, host)
+			},
+		})
+		if err != nil {
+			log.Fatal("ACME 配置错误: ", err)
+		}
+	}
+	obtainCerts := func() {
+		certStore.Obtain(append(proxyManager.Hosts(), serverConfig.ACMEHosts...))
+	}
+	configManager.OnChange(obtainCerts)
+
+	// 创建API处理器
+	apiHandler := handlers.NewAPIHandler(configManager, proxyManager, certStore)
 
 	// 创建路由
 	mux := http.NewServeMux()
@@ -69,6 +111,20 @@ func main() {
 	})))
 
 	mux.HandleFunc("/api/rules/toggle", corsMiddleware(auth.AuthMiddleware(apiHandler.ToggleRule)))
+	mux.HandleFunc("/api/health", corsMiddleware(auth.AuthMiddleware(apiHandler.GetHealth)))
+	mux.HandleFunc("/api/websockets", corsMiddleware(auth.AuthMiddleware(apiHandler.GetWebSockets)))
+	mux.HandleFunc("/api/certs", corsMiddleware(auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			apiHandler.ListCerts(w, r)
+		case http.MethodPost:
+			apiHandler.UploadCert(w, r)
+		case http.MethodDelete:
+			apiHandler.DeleteCert(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
 	mux.HandleFunc("/api/change-password", corsMiddleware(auth.AuthMiddleware(apiHandler.ChangePassword)))
 
 	// 管理面板路由
@@ -81,26 +137,10 @@ func main() {
 	fileServer := http.FileServer(http.FS(staticFS))
 	mux.Handle("/admin/", http.StripPrefix("/admin/", fileServer))
 
-	// 代理路由（处理所有其他请求）
+	// 未匹配任何代理规则的请求
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-
-		// 检查是否匹配任何代理规则
-		rules := configManager.GetEnabledRules()
-		for _, rule := range rules {
-			prefix := rule.Path
-			if !strings.HasPrefix(prefix, "/") {
-				prefix = "/" + prefix
-			}
-
-			if strings.HasPrefix(path, prefix+"/") || path == prefix {
-				proxyManager.ServeHTTP(w, r)
-				return
-			}
-		}
-
 		// 如果是根路径，显示欢迎页面（不显示管理按钮）
-		if path == "/" {
+		if r.URL.Path == "/" {
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			w.Write([]byte(`<!DOCTYPE html>
 <html>
@@ -156,11 +196,71 @@ func main() {
 	log.Printf("========================================")
 	log.Printf("  反向代理服务已启动")
 	log.Printf("  服务地址: http://localhost%s", addr)
-	log.Printf("  管理面板: http://localhost%s/admin/", addr)
+	adminHost := "localhost"
+	if serverConfig.AdminHost != "" {
+		adminHost = serverConfig.AdminHost
+	}
+	log.Printf("  管理面板: http://%s%s/admin/", adminHost, addr)
 	log.Printf("  默认账号: admin / admin123")
 	log.Printf("========================================")
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if serverConfig.ProxyProtocol {
+		log.Printf("  已启用 PROXY protocol，受信任网段: %v", serverConfig.ProxyProtocolNetworks)
+	}
+
+	// 明文端口按配置将请求重定向到 HTTPS
+	httpsPort := ""
+	if serverConfig.HTTPSAddr != "" {
+		_, httpsPort, _ = net.SplitHostPort(serverConfig.HTTPSAddr)
+	}
+	proxyManager.SetHTTPSRedirect(serverConfig.HTTPSRedirect, httpsPort)
+	// HTTP-01 验证请求不受重定向影响
+	// 配置了域名的规则优先于管理面板和 API 路径，其余请求先匹配管理路径再匹配代理规则
+	entry := proxyManager.Handler(mux, serverConfig.AdminHost)
+	handler := certStore.HTTPHandler(proxyManager.RedirectHTTPS(entry))
+
+	if serverConfig.HTTPSAddr != "" {
+		if serverConfig.ACME {
+			log.Printf("  已启用 ACME 自动签发证书")
+		} else if len(certStore.List()) == 0 {
+			log.Printf("  警告: 尚未配置 HTTPS 证书，可通过 TLS_CERT_FILE/TLS_KEY_FILE 或 /api/certs 添加")
+		}
+		ln := listen(serverConfig.HTTPSAddr, serverConfig)
+		tlsListener := tls.NewListener(ln, &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     certStore.NextProtos(),
+			GetCertificate: certStore.GetCertificate,
+		})
+		log.Printf("  HTTPS 地址: https://localhost%s", serverConfig.HTTPSAddr)
+		go func() {
+			log.Fatal("HTTPS 服务器启动失败:", http.Serve(tlsListener, entry))
+		}()
+	}
+
+	if serverConfig.H2C {
+		// 明文端口同时接受 HTTP/1.1 和 HTTP/2（prior knowledge 或 Upgrade: h2c）
+		handler = h2c.NewHandler(handler, &http2.Server{})
+		log.Printf("  已启用 h2c（明文 HTTP/2）")
+	}
+
+	ln := listen(addr, serverConfig)
+	// 监听器就绪后再申请证书，以便应答验证请求
+	obtainCerts()
+
+	if err := http.Serve(ln, handler); err != nil {
 		log.Fatal("服务器启动失败:", err)
 	}
 }
+
+// listen 监听 TCP 地址，按配置解析 PROXY protocol 头
+func listen(addr string, serverConfig config.ServerConfig) net.Listener {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("服务器启动失败:", err)
+	}
+	if serverConfig.ProxyProtocol {
+		// 前置四层负载均衡通过 PROXY protocol 传递客户端真实地址
+		ln = listener.NewProxyProtocolListener(ln, serverConfig.ProxyProtocolNetworks)
+	}
+	return ln
+}
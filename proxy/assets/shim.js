@@ -0,0 +1,239 @@
+// go_proxy_every 客户端地址重写脚本
+// 在浏览器中拦截运行时构造的请求地址，映射到代理规则的路径前缀之下
+(function () {
+    'use strict';
+
+    var script = document.currentScript;
+    if (!script || window.__proxyEveryShim) {
+        return;
+    }
+    window.__proxyEveryShim = true;
+
+    var prefix = script.getAttribute('data-prefix') || '';
+    // 主上游地址自身的路径，根相对地址只有落在其下时才映射
+    var root = script.getAttribute('data-root') || '';
+    // 每项格式为 host/path=base，base 为该上游映射到的代理路径
+    var origins = (script.getAttribute('data-origins') || '').split(' ').filter(Boolean).map(function (o) {
+        var eq = o.indexOf('=');
+        var base = eq < 0 ? prefix : o.slice(eq + 1);
+        var origin = eq < 0 ? o : o.slice(0, eq);
+        var i = origin.indexOf('/');
+        return {
+            host: (i < 0 ? origin : origin.slice(0, i)).toLowerCase(),
+            path: i < 0 ? '' : origin.slice(i),
+            base: base
+        };
+    });
+
+    var absolutePattern = /^(?:(https?|wss?):)?\/\/([^\/?#]*)(.*)$/i;
+
+    // isBoundary 判断剩余部分是否从路径边界开始
+    function isBoundary(rest) {
+        return rest === '' || rest[0] === '/' || rest[0] === '?' || rest[0] === '#';
+    }
+
+    // join 将上游路径拼接到代理路径 base 之下
+    function join(base, path) {
+        if (path === '' || path[0] !== '/') {
+            path = '/' + path;
+        }
+        if (base === '') {
+            return path;
+        }
+        return path === '/' ? base + '/' : base + path;
+    }
+
+    // addPrefix 为根相对地址添加前缀，已在前缀之下的地址保持不变
+    // 上游地址带路径时先去掉该路径，不在其下的地址保持不变
+    function addPrefix(path) {
+        if (root !== '') {
+            if (path.indexOf(root) !== 0 || !isBoundary(path.slice(root.length))) {
+                return path;
+            }
+            return join(prefix, path.slice(root.length));
+        }
+        if (prefix === '' || path === prefix || (path.indexOf(prefix) === 0 && isBoundary(path.slice(prefix.length)))) {
+            return path;
+        }
+        return join(prefix, path);
+    }
+
+    // wsOrigin 当前页面对应的 WebSocket 源
+    function wsOrigin() {
+        return (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host;
+    }
+
+    // map 将上游地址或根相对地址映射为代理地址，ws 为 true 时返回 WebSocket 绝对地址
+    function map(url, ws) {
+        if (typeof url !== 'string') {
+            return url;
+        }
+        var value = url.trim();
+
+        var m = absolutePattern.exec(value);
+        if (m) {
+            var host = m[2].toLowerCase();
+            var rest = m[3];
+            if (host === location.host.toLowerCase()) {
+                if (rest === '' || rest[0] !== '/') {
+                    rest = '/' + rest;
+                }
+                var same = addPrefix(rest);
+                return ws ? wsOrigin() + same : same;
+            }
+            for (var i = 0; i < origins.length; i++) {
+                var o = origins[i];
+                if (host !== o.host || !isBoundary(rest) || rest.indexOf(o.path) !== 0 || !isBoundary(rest.slice(o.path.length))) {
+                    continue;
+                }
+                var mapped = join(o.base, rest.slice(o.path.length));
+                return ws ? wsOrigin() + mapped : mapped;
+            }
+            return url;
+        }
+
+        if (value[0] === '/') {
+            var path = addPrefix(value);
+            return ws ? wsOrigin() + path : path;
+        }
+        return url;
+    }
+
+    // 重写 fetch
+    if (window.fetch) {
+        var originalFetch = window.fetch;
+        window.fetch = function (input, init) {
+            if (typeof input === 'string') {
+                input = map(input);
+            } else if (window.URL && input instanceof URL) {
+                input = map(input.href);
+            } else if (window.Request && input instanceof Request) {
+                var mapped = map(input.url);
+                if (mapped !== input.url) {
+                    input = new Request(mapped, input);
+                }
+            }
+            return originalFetch.call(this, input, init);
+        };
+    }
+
+    // 重写 XMLHttpRequest
+    if (window.XMLHttpRequest) {
+        var originalOpen = XMLHttpRequest.prototype.open;
+        XMLHttpRequest.prototype.open = function (method, url) {
+            var args = Array.prototype.slice.call(arguments);
+            args[1] = map(String(url));
+            return originalOpen.apply(this, args);
+        };
+    }
+
+    // 重写 history.pushState / replaceState
+    ['pushState', 'replaceState'].forEach(function (name) {
+        var original = history[name];
+        if (!original) {
+            return;
+        }
+        history[name] = function (state, title, url) {
+            var args = Array.prototype.slice.call(arguments);
+            if (url !== undefined && url !== null) {
+                args[2] = map(String(url));
+            }
+            return original.apply(this, args);
+        };
+    });
+
+    // wrapConstructor 包装 WebSocket、EventSource 等构造函数，改写第一个参数
+    function wrapConstructor(name, ws) {
+        var Original = window[name];
+        if (!Original) {
+            return;
+        }
+        var Wrapped = function (url) {
+            var args = Array.prototype.slice.call(arguments);
+            args[0] = map(String(url), ws);
+            return new (Function.prototype.bind.apply(Original, [null].concat(args)))();
+        };
+        Wrapped.prototype = Original.prototype;
+        Object.getOwnPropertyNames(Original).forEach(function (key) {
+            if (!(key in Wrapped)) {
+                try {
+                    Wrapped[key] = Original[key];
+                } catch (e) {}
+            }
+        });
+        window[name] = Wrapped;
+    }
+
+    wrapConstructor('WebSocket', true);
+    wrapConstructor('EventSource', false);
+
+    // 启用 Cookie 命名空间时，页面脚本看到和写入的 Cookie 与服务端的改写保持一致
+    var namespace = script.getAttribute('data-cookie-namespace') || '';
+    var cookieDesc = window.Document && Object.getOwnPropertyDescriptor(Document.prototype, 'cookie');
+    if (namespace !== '' && cookieDesc && cookieDesc.configurable && cookieDesc.get && cookieDesc.set) {
+        // isSpecialCookie 带有 __Host- 或 __Secure- 前缀的 Cookie 不能改名
+        var isSpecialCookie = function (pair) {
+            return pair.indexOf('__Host-') === 0 || pair.indexOf('__Secure-') === 0;
+        };
+
+        // cookiePath 将 Cookie Path 映射到规则前缀之下，已在前缀之下的路径保持不变
+        var cookiePath = function (path) {
+            if (path[0] !== '/') {
+                path = '/';
+            }
+            if (prefix !== '' && (path === prefix || (path.indexOf(prefix) === 0 && isBoundary(path.slice(prefix.length))))) {
+                return path;
+            }
+            if (root !== '') {
+                if (path.indexOf(root) === 0 && isBoundary(path.slice(root.length))) {
+                    path = path.slice(root.length) || '/';
+                } else if (root.indexOf(path) === 0 && (path[path.length - 1] === '/' || root[path.length] === '/')) {
+                    path = '/';
+                }
+            }
+            if (prefix === '') {
+                return path;
+            }
+            return path === '/' ? prefix : prefix + path;
+        };
+
+        Object.defineProperty(document, 'cookie', {
+            configurable: true,
+            // 只返回本规则的 Cookie 并还原名称
+            get: function () {
+                var kept = [];
+                cookieDesc.get.call(document).split(';').forEach(function (pair) {
+                    pair = pair.trim();
+                    if (pair.indexOf(namespace) === 0) {
+                        kept.push(pair.slice(namespace.length));
+                    } else if (isSpecialCookie(pair)) {
+                        kept.push(pair);
+                    }
+                });
+                return kept.join('; ');
+            },
+            // 为名称添加命名空间，去掉 Domain 并改写 Path
+            set: function (value) {
+                var parts = String(value).split(';');
+                var pair = parts[0].trim();
+                var hostOnly = pair.indexOf('__Host-') === 0;
+                var out = [pair !== '' && !isSpecialCookie(pair) ? namespace + pair : pair];
+                for (var i = 1; i < parts.length; i++) {
+                    var attr = parts[i].trim();
+                    var eq = attr.indexOf('=');
+                    var key = (eq < 0 ? attr : attr.slice(0, eq)).trim().toLowerCase();
+                    if (key === 'domain') {
+                        continue;
+                    }
+                    if (key === 'path' && !hostOnly) {
+                        attr = 'Path=' + cookiePath(eq < 0 ? '' : attr.slice(eq + 1).trim());
+                    }
+                    if (attr !== '') {
+                        out.push(attr);
+                    }
+                }
+                cookieDesc.set.call(document, out.join('; '));
+            }
+        });
+    }
+})();
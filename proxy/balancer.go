@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 负载均衡策略
+const (
+	StrategyRoundRobin = "round_robin"
+	StrategyWeighted   = "weighted"
+	StrategyLeastConn  = "least_conn"
+	StrategyRandom     = "random"
+	StrategyIPHash     = "ip_hash"
+	StrategyHeaderHash = "header_hash"
+)
+
+// Strategies 支持的负载均衡策略
+var Strategies = []string{
+	StrategyRoundRobin,
+	StrategyWeighted,
+	StrategyLeastConn,
+	StrategyRandom,
+	StrategyIPHash,
+	StrategyHeaderHash,
+}
+
+// ringReplicas 一致性哈希中每单位权重的虚拟节点数
+const ringReplicas = 100
+
+// maxRingNodes 一致性哈希环的最大虚拟节点数，超过时按比例减少每单位权重的节点数
+const maxRingNodes = 100000
+
+// MaxWeight 上游权重上限
+const MaxWeight = 1000
+
+// upstream 上游实例
+type upstream struct {
+	url       *url.URL
+	weight    int
+	active    atomic.Int64 // 进行中的请求数
+	available atomic.Bool  // 是否参与负载均衡
+	health    healthState
+
+	currentWeight int // 平滑加权轮询的当前权重，由 balancer.mu 保护
+}
+
+// ringNode 一致性哈希环上的虚拟节点
+type ringNode struct {
+	hash     uint32
+	upstream *upstream
+}
+
+// balancer 负载均衡器
+type balancer struct {
+	strategy   string
+	hashHeader string
+	upstreams  []*upstream
+	ring       []ringNode
+	health     *healthChecker
+
+	mu      sync.Mutex
+	counter atomic.Uint64
+}
+
+// newBalancer 根据规则创建负载均衡器，未配置上游列表时使用 Target
+func newBalancer(rule config.ProxyRule, transport http.RoundTripper) *balancer {
+	b := &balancer{
+		strategy:   rule.LoadBalance,
+		hashHeader: rule.HashHeader,
+	}
+	if b.strategy == "" {
+		b.strategy = StrategyRoundRobin
+	}
+
+	upstreams := rule.Upstreams
+	if len(upstreams) == 0 && rule.Target != "" {
+		upstreams = []config.Upstream{{URL: rule.Target, Weight: 1}}
+	}
+
+	for _, u := range upstreams {
+		target, err := url.Parse(u.URL)
+		if err != nil || target.Host == "" {
+			log.Printf("[Balancer] 规则 %s 的上游地址无效，已忽略: %s", rule.Name, u.URL)
+			continue
+		}
+		weight := u.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		if weight > MaxWeight {
+			log.Printf("[Balancer] 规则 %s 的上游权重 %d 超过上限，按 %d 处理: %s", rule.Name, weight, MaxWeight, u.URL)
+			weight = MaxWeight
+		}
+		up := &upstream{url: target, weight: weight}
+		up.available.Store(true)
+		up.health.healthy = true
+		b.upstreams = append(b.upstreams, up)
+	}
+
+	if b.strategy == StrategyIPHash || b.strategy == StrategyHeaderHash {
+		b.buildRing()
+	}
+
+	b.health = newHealthChecker(rule.HealthCheck, transport)
+	if b.health != nil && b.health.active && len(b.upstreams) > 0 {
+		go b.health.run(b.upstreams)
+	}
+
+	return b
+}
+
+// close 释放负载均衡器资源
+func (b *balancer) close() {
+	b.health.close()
+}
+
+// setAvailable 设置上游是否参与负载均衡
+func (u *upstream) setAvailable(available bool) {
+	u.available.Store(available)
+}
+
+// candidates 返回可参与负载均衡的上游，全部可用时直接返回原切片
+// 不可用的上游获得重试机会时只返回该上游，保证放行的请求确实发往它
+func (b *balancer) candidates() []*upstream {
+	for i, u := range b.upstreams {
+		if u.available.Load() {
+			continue
+		}
+
+		// 存在不可用的上游，构建可用列表
+		list := make([]*upstream, 0, len(b.upstreams)-1)
+		list = append(list, b.upstreams[:i]...)
+		for _, u := range b.upstreams[i:] {
+			if u.available.Load() {
+				list = append(list, u)
+			} else if b.health.retryable(u) {
+				return []*upstream{u}
+			}
+		}
+		return list
+	}
+	return b.upstreams
+}
+
+// buildRing 构建一致性哈希环
+func (b *balancer) buildRing() {
+	total := 0
+	for _, u := range b.upstreams {
+		total += u.weight
+	}
+	replicas := ringReplicas
+	if total*replicas > maxRingNodes {
+		replicas = max(maxRingNodes/total, 1)
+	}
+
+	for i, u := range b.upstreams {
+		for j := 0; j < u.weight*replicas; j++ {
+			b.ring = append(b.ring, ringNode{
+				hash:     hashKey(u.url.String() + "#" + strconv.Itoa(i) + "-" + strconv.Itoa(j)),
+				upstream: u,
+			})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+}
+
+// pick 为请求选择上游，clientIP 为解析后的客户端真实地址，没有可用上游时返回 nil
+func (b *balancer) pick(r *http.Request, clientIP string) *upstream {
+	upstreams := b.candidates()
+	if len(upstreams) == 0 {
+		return nil
+	}
+	if len(upstreams) == 1 {
+		return upstreams[0]
+	}
+
+	switch b.strategy {
+	case StrategyWeighted:
+		return b.pickWeighted(upstreams)
+	case StrategyLeastConn:
+		return pickLeastConn(upstreams)
+	case StrategyRandom:
+		return pickRandom(upstreams)
+	case StrategyIPHash:
+		return b.pickHash(upstreams, clientIP)
+	case StrategyHeaderHash:
+		return b.pickHash(upstreams, r.Header.Get(b.hashHeader))
+	default:
+		n := b.counter.Add(1) - 1
+		return upstreams[n%uint64(len(upstreams))]
+	}
+}
+
+// pickWeighted 平滑加权轮询（与 nginx 相同的算法）
+func (b *balancer) pickWeighted(upstreams []*upstream) *upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *upstream
+	total := 0
+	for _, u := range upstreams {
+		u.currentWeight += u.weight
+		total += u.weight
+		if best == nil || u.currentWeight > best.currentWeight {
+			best = u
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// pickLeastConn 选择（按权重折算后）进行中请求最少的上游
+func pickLeastConn(upstreams []*upstream) *upstream {
+	var best *upstream
+	var bestLoad float64
+	for _, u := range upstreams {
+		load := float64(u.active.Load()) / float64(u.weight)
+		if best == nil || load < bestLoad {
+			best, bestLoad = u, load
+		}
+	}
+	return best
+}
+
+// pickRandom 按权重随机选择
+func pickRandom(upstreams []*upstream) *upstream {
+	total := 0
+	for _, u := range upstreams {
+		total += u.weight
+	}
+	n := rand.Intn(total)
+	for _, u := range upstreams {
+		if n < u.weight {
+			return u
+		}
+		n -= u.weight
+	}
+	return upstreams[len(upstreams)-1]
+}
+
+// pickHash 一致性哈希选择，相同的键总是落到同一上游
+// 命中的上游不可用时顺时针寻找下一个可用上游
+func (b *balancer) pickHash(upstreams []*upstream, key string) *upstream {
+	if key == "" || len(b.ring) == 0 {
+		return pickRandom(upstreams)
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+	for n := 0; n < len(b.ring); n++ {
+		u := b.ring[(start+n)%len(b.ring)].upstream
+		if slices.Contains(upstreams, u) {
+			return u
+		}
+	}
+	return pickRandom(upstreams)
+}
+
+// origins 返回所有上游地址，用于重写响应中的链接
+func (b *balancer) origins() []*url.URL {
+	origins := make([]*url.URL, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		origins = append(origins, u.url)
+	}
+	return origins
+}
+
+// hashKey 计算字符串哈希
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"fmt"
+	"go_proxy_every/config"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestBalancer 创建使用给定策略的负载均衡器，上游依次为 http://10.0.0.1、http://10.0.0.2 ...
+func newTestBalancer(t *testing.T, strategy string, weights ...int) *balancer {
+	t.Helper()
+	rule := config.ProxyRule{Name: strategy, LoadBalance: strategy, HashHeader: "X-User"}
+	for i, w := range weights {
+		rule.Upstreams = append(rule.Upstreams, config.Upstream{URL: fmt.Sprintf("http://10.0.0.%d", i+1), Weight: w})
+	}
+	b := newBalancer(rule, nil)
+	t.Cleanup(b.close)
+	return b
+}
+
+// picks 连续选择 n 次，返回选中上游的主机名
+func picks(b *balancer, n int) []string {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = b.pick(r, "").url.Host
+	}
+	return hosts
+}
+
+func TestBalancerOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		weights  []int
+		want     []string
+	}{
+		{"轮询", StrategyRoundRobin, []int{1, 1, 1}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"未指定策略时轮询", "", []int{1, 1}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.2"}},
+		// 平滑加权轮询不会连续把请求集中到权重大的上游
+		{"平滑加权", StrategyWeighted, []int{5, 1, 1}, []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3", "10.0.0.1", "10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBalancer(t, tt.strategy, tt.weights...)
+			if got := picks(b, len(tt.want)); !slices.Equal(got, tt.want) {
+				t.Fatalf("选择顺序应为 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBalancerWeightedRatio(t *testing.T) {
+	b := newTestBalancer(t, StrategyWeighted, 3, 2, 1)
+	counts := make(map[string]int)
+	for _, host := range picks(b, 600) {
+		counts[host]++
+	}
+	want := map[string]int{"10.0.0.1": 300, "10.0.0.2": 200, "10.0.0.3": 100}
+	if !maps.Equal(counts, want) {
+		t.Fatalf("请求应按权重 3:2:1 分配，实际 %v", counts)
+	}
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		active  []int64
+		want    string
+	}{
+		{"进行中请求最少", []int{1, 1, 1}, []int64{3, 1, 2}, "10.0.0.2"},
+		{"按权重折算", []int{4, 1, 1}, []int64{3, 1, 2}, "10.0.0.1"},
+		{"负载相同时选第一个", []int{1, 1}, []int64{2, 2}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBalancer(t, StrategyLeastConn, tt.weights...)
+			for i, n := range tt.active {
+				b.upstreams[i].active.Store(n)
+			}
+			if got := picks(b, 1)[0]; got != tt.want {
+				t.Fatalf("应选择 %s，实际 %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBalancerHashSticky(t *testing.T) {
+	tests := []struct {
+		strategy string
+		request  func(key string) (*http.Request, string)
+	}{
+		{StrategyIPHash, func(key string) (*http.Request, string) {
+			return httptest.NewRequest(http.MethodGet, "/", nil), key
+		}},
+		{StrategyHeaderHash, func(key string) (*http.Request, string) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", key)
+			return r, "203.0.113.1"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			b := newTestBalancer(t, tt.strategy, 1, 1, 1)
+			used := make(map[*upstream]bool)
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("192.0.2.%d", i)
+				first := b.pick(tt.request(key))
+				used[first] = true
+				for j := 0; j < 5; j++ {
+					if u := b.pick(tt.request(key)); u != first {
+						t.Fatalf("%s 应始终落到 %s，实际 %s", key, first.url, u.url)
+					}
+				}
+			}
+			if len(used) != len(b.upstreams) {
+				t.Fatalf("不同的键应分散到全部 %d 个上游，实际 %d 个", len(b.upstreams), len(used))
+			}
+		})
+	}
+}
+
+func TestBalancerHashFailover(t *testing.T) {
+	b := newTestBalancer(t, StrategyIPHash, 1, 1, 1)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	before := make(map[string]*upstream)
+	for i := 0; i < 50; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		before[ip] = b.pick(r, ip)
+	}
+
+	down := b.upstreams[0]
+	down.setAvailable(false)
+	moved := 0
+	for ip, u := range before {
+		got := b.pick(r, ip)
+		if u != down {
+			// 其他上游上的客户端不受影响
+			if got != u {
+				t.Fatalf("%s 原本落到可用的 %s，实际改为 %s", ip, u.url, got.url)
+			}
+			continue
+		}
+		// 顺时针找到的下一个其他上游的节点
+		h := hashKey(ip)
+		i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+		for b.ring[i%len(b.ring)].upstream == down {
+			i++
+		}
+		if want := b.ring[i%len(b.ring)].upstream; got != want {
+			t.Fatalf("%s 应转移到哈希环上的下一个上游 %s，实际 %s", ip, want.url, got.url)
+		}
+		moved++
+	}
+	if moved == 0 {
+		t.Fatal("应有客户端原本落到不可用的上游")
+	}
+}
+
+func TestBuildRingLimitsSize(t *testing.T) {
+	rule := config.ProxyRule{
+		Name:        "hash",
+		LoadBalance: StrategyIPHash,
+		Upstreams: []config.Upstream{
+			{URL: "http://10.0.0.1", Weight: 10000000},
+			{URL: "http://10.0.0.2", Weight: MaxWeight},
+			{URL: "http://10.0.0.3", Weight: 1},
+		},
+	}
+	b := newBalancer(rule, nil)
+	defer b.close()
+
+	if w := b.upstreams[0].weight; w != MaxWeight {
+		t.Fatalf("超过上限的权重应按 %d 处理，实际 %d", MaxWeight, w)
+	}
+	if n := len(b.ring); n == 0 || n > maxRingNodes {
+		t.Fatalf("哈希环节点数应在 1 到 %d 之间，实际 %d", maxRingNodes, n)
+	}
+
+	// 每个上游至少保留一个节点
+	seen := make(map[*upstream]bool)
+	for _, node := range b.ring {
+		seen[node.upstream] = true
+	}
+	if len(seen) != len(b.upstreams) {
+		t.Fatalf("哈希环应包含全部 %d 个上游，实际 %d", len(b.upstreams), len(seen))
+	}
+}
+
+func TestPassiveRetryGoesToDownUpstream(t *testing.T) {
+	rule := config.ProxyRule{
+		Name: "passive",
+		Upstreams: []config.Upstream{
+			{URL: "http://10.0.0.1"},
+			{URL: "http://10.0.0.2"},
+			{URL: "http://10.0.0.3"},
+		},
+		HealthCheck: config.HealthCheck{Passive: true, Interval: 1, UnhealthyThreshold: 1},
+	}
+	b := newBalancer(rule, nil)
+	defer b.close()
+
+	down := b.upstreams[1]
+	b.health.observe(down, http.StatusBadGateway, nil)
+	if down.available.Load() {
+		t.Fatal("达到失败阈值后上游应不可用")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < 10; i++ {
+		if u := b.pick(r, ""); u == down {
+			t.Fatal("未到重试间隔时不应选中不可用的上游")
+		}
+	}
+
+	// 超过探测间隔后，放行的重试请求必须发往不可用的上游本身
+	for round := 0; round < 3; round++ {
+		down.health.mu.Lock()
+		down.health.downSince = time.Now().Add(-time.Minute)
+		down.health.lastRetry = time.Time{}
+		down.health.mu.Unlock()
+		if u := b.pick(r, ""); u != down {
+			t.Fatalf("第 %d 次重试请求应发往 %s，实际 %s", round+1, down.url, u.url)
+		}
+		if u := b.pick(r, ""); u == down {
+			t.Fatal("一个间隔内只应放行一个重试请求")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// cookieNamespace 规则的 Cookie 名称前缀，基于规则ID，修改规则时保持不变
+func (rt *route) cookieNamespace() string {
+	if !rt.rule.CookieNamespace {
+		return ""
+	}
+	id := strings.ReplaceAll(rt.rule.ID, "-", "")
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return "pe" + id + "_"
+}
+
+// rewriteSetCookies 重写上游的 Set-Cookie：
+// 移除 Domain 使 Cookie 只属于代理域名，Path 限定在规则前缀之下，并按需为名称添加命名空间
+func rewriteSetCookies(h http.Header, rt *route, links *linkRewriter) {
+	cookies := h.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+
+	namespace := rt.cookieNamespace()
+	rewritten := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		rewritten = append(rewritten, rewriteSetCookie(c, links, namespace))
+	}
+	h["Set-Cookie"] = rewritten
+}
+
+// rewriteSetCookie 重写单个 Set-Cookie 值
+func rewriteSetCookie(value string, links *linkRewriter, namespace string) string {
+	parts := strings.Split(value, ";")
+
+	pair := strings.TrimSpace(parts[0])
+	hostOnly := strings.HasPrefix(pair, "__Host-")
+	if namespace != "" && pair != "" && !hostOnly && !strings.HasPrefix(pair, "__Secure-") {
+		pair = namespace + pair
+	}
+
+	out := []string{pair}
+	// __Host- Cookie 必须使用 Path=/，不做路径改写
+	hasPath := hostOnly
+	for _, attr := range parts[1:] {
+		attr = strings.TrimSpace(attr)
+		key, val, _ := strings.Cut(attr, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "domain":
+			// 去掉 Domain，Cookie 只对代理域名生效
+			continue
+		case "path":
+			if !hostOnly {
+				hasPath = true
+				attr = "Path=" + links.cookiePath(strings.TrimSpace(val))
+			}
+		}
+		if attr != "" {
+			out = append(out, attr)
+		}
+	}
+	if !hasPath && links.prefix != "" {
+		out = append(out, "Path="+links.cookiePath("/"))
+	}
+
+	return strings.Join(out, "; ")
+}
+
+// cookiePath 将上游的 Cookie Path 映射到规则前缀之下
+// 上游地址带路径时先去掉该路径，覆盖整个上游路径的 Path（如 / 或 /app）映射为规则前缀
+func (lr *linkRewriter) cookiePath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/"
+	}
+	if lr.root != "" {
+		switch {
+		case strings.HasPrefix(path, lr.root) && isPathBoundary(path[len(lr.root):]):
+			path = path[len(lr.root):]
+		case strings.HasPrefix(lr.root, path) && (strings.HasSuffix(path, "/") || lr.root[len(path)] == '/'):
+			path = "/"
+		}
+		if path == "" {
+			path = "/"
+		}
+	}
+	if lr.prefix == "" {
+		return path
+	}
+	if path == "/" {
+		return lr.prefix
+	}
+	return lr.prefix + path
+}
+
+// filterRequestCookies 启用命名空间时，只向上游转发本规则的 Cookie 并还原名称
+func filterRequestCookies(req *http.Request, rt *route) {
+	namespace := rt.cookieNamespace()
+	if namespace == "" {
+		return
+	}
+
+	var kept []string
+	for _, line := range req.Header.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			pair = strings.TrimSpace(pair)
+			switch {
+			case strings.HasPrefix(pair, namespace):
+				kept = append(kept, strings.TrimPrefix(pair, namespace))
+			case strings.HasPrefix(pair, "__Host-"), strings.HasPrefix(pair, "__Secure-"):
+				// 带有特殊前缀的 Cookie 不能改名，原样转发
+				kept = append(kept, pair)
+			}
+		}
+	}
+
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
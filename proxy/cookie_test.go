@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewriteSetCookie(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		target    string
+		namespace string
+		in        string
+		want      string
+	}{
+		{"去掉 Domain 并补充 Path", "/p", "http://up", "", "s=1; Domain=up; HttpOnly", "s=1; HttpOnly; Path=/p"},
+		{"根路径", "/p", "http://up", "", "s=1; Path=/", "s=1; Path=/p"},
+		{"子路径", "/p", "http://up", "", "s=1; Path=/admin", "s=1; Path=/p/admin"},
+		{"相对路径按根路径处理", "/p", "http://up", "", "s=1; Path=admin", "s=1; Path=/p"},
+		{"上游路径本身", "/p", "http://up/app", "", "s=1; Path=/app", "s=1; Path=/p"},
+		{"上游路径带结尾斜杠", "/p", "http://up/app", "", "s=1; Path=/app/", "s=1; Path=/p"},
+		{"上游路径之下", "/p", "http://up/app", "", "s=1; Path=/app/admin", "s=1; Path=/p/admin"},
+		{"上游路径的上级", "/p", "http://up/app/v1", "", "s=1; Path=/app", "s=1; Path=/p"},
+		{"上游路径带根路径", "/p", "http://up/app", "", "s=1; Path=/", "s=1; Path=/p"},
+		{"上游路径带默认路径", "/p", "http://up/app", "", "s=1", "s=1; Path=/p"},
+		{"保留前缀", "", "http://up/app", "", "s=1; Path=/app/p", "s=1; Path=/p"},
+		{"根规则", "", "http://up", "", "s=1; Domain=up", "s=1"},
+		{"命名空间", "/p", "http://up", "pe1234_", "s=1; Path=/", "pe1234_s=1; Path=/p"},
+		{"__Secure- 不改名", "/p", "http://up", "pe1234_", "__Secure-s=1; Secure", "__Secure-s=1; Secure; Path=/p"},
+		{"__Host- 不改名也不改路径", "/p", "http://up", "pe1234_", "__Host-s=1; Secure; Path=/", "__Host-s=1; Secure; Path=/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := newTestLinks(t, tt.prefix, tt.target)
+			if got := rewriteSetCookie(tt.in, links, tt.namespace); got != tt.want {
+				t.Fatalf("%q 应重写为 %q，实际 %q", tt.in, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterRequestCookies(t *testing.T) {
+	rt := &route{rule: config.ProxyRule{ID: "1234-5678-9abc", CookieNamespace: true}}
+	namespace := rt.cookieNamespace()
+	if namespace != "pe12345678_" {
+		t.Fatalf("命名空间应为 pe12345678_，实际 %q", namespace)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Cookie", namespace+"sid=a; other=b; pe87654321_sid=c")
+	req.Header.Add("Cookie", "__Host-id=d; __Secure-t=e; "+namespace+"theme=dark")
+	filterRequestCookies(req, rt)
+
+	if got, want := req.Header.Get("Cookie"), "sid=a; __Host-id=d; __Secure-t=e; theme=dark"; got != want {
+		t.Fatalf("转发的 Cookie 应为 %q，实际 %q", want, got)
+	}
+	if n := len(req.Header.Values("Cookie")); n != 1 {
+		t.Fatalf("应合并为一个 Cookie 头，实际 %d 个", n)
+	}
+
+	// 没有本规则的 Cookie 时不转发 Cookie 头
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "other=b")
+	filterRequestCookies(req, rt)
+	if _, ok := req.Header["Cookie"]; ok {
+		t.Fatalf("不应转发其他规则的 Cookie: %q", req.Header.Get("Cookie"))
+	}
+
+	// 未启用命名空间时原样转发
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "other=b")
+	filterRequestCookies(req, &route{})
+	if got := req.Header.Get("Cookie"); got != "other=b" {
+		t.Fatalf("未启用命名空间时应原样转发，实际 %q", got)
+	}
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// supportedEncodings 支持解码和重新压缩的编码，按压缩时的偏好顺序排列
+var supportedEncodings = []string{"br", "zstd", "gzip", "deflate"}
+
+// brotliLevel 重新压缩时使用的 brotli 级别，兼顾速度与压缩率
+const brotliLevel = 4
+
+// filterAcceptEncoding 只保留可以解码的编码，转发给上游
+// 这样上游无论选择哪种压缩方式，需要重写时都能解码
+func filterAcceptEncoding(header string) string {
+	var accepted []string
+	for _, part := range strings.Split(header, ",") {
+		name, q := parseCoding(part)
+		if q <= 0 || !isSupportedEncoding(name) {
+			continue
+		}
+		accepted = append(accepted, strings.TrimSpace(part))
+	}
+	return strings.Join(accepted, ", ")
+}
+
+// negotiateEncoding 根据客户端的 Accept-Encoding 选择重新压缩的编码，返回空字符串表示不压缩
+func negotiateEncoding(header string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, q := parseCoding(part)
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		if name != "" {
+			qualities[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supportedEncodings {
+		q, ok := qualities[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// parseCoding 解析 Accept-Encoding 中的单项，返回小写编码名和权重
+func parseCoding(part string) (string, float64) {
+	name, params, _ := strings.Cut(part, ";")
+	name = strings.ToLower(strings.TrimSpace(name))
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(key, "q") {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return name, q
+}
+
+// isSupportedEncoding 判断是否支持该编码
+func isSupportedEncoding(encoding string) bool {
+	for _, enc := range supportedEncodings {
+		if enc == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBody 按 Content-Encoding 解码响应体，不支持的编码返回 false
+func decodeBody(body io.ReadCloser, encoding string) (io.ReadCloser, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, true, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, true, err
+		}
+		return &readCloser{Reader: gz, closers: []io.Closer{gz, body}}, true, nil
+	case "deflate":
+		// HTTP 的 deflate 应为 zlib 格式，但部分服务器发送裸 deflate 数据
+		br := bufio.NewReader(body)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, true, err
+			}
+			return &readCloser{Reader: zr, closers: []io.Closer{zr, body}}, true, nil
+		}
+		fr := flate.NewReader(br)
+		return &readCloser{Reader: fr, closers: []io.Closer{fr, body}}, true, nil
+	case "br":
+		return &readCloser{Reader: brotli.NewReader(body), closers: []io.Closer{body}}, true, nil
+	case "zstd":
+		// 上游响应不可信：单协程解码，并限制帧窗口大小，避免构造的响应占用大量内存
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, true, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), body}}, true, nil
+	}
+	return nil, false, nil
+}
+
+// zstdMaxWindow 解码上游 zstd 响应时允许的最大帧窗口
+const zstdMaxWindow = 8 << 20
+
+// isZlibHeader 判断是否为 zlib 头
+func isZlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
+
+// encodeBody 将响应体按指定编码流式压缩
+// 每读到一块内容就刷新压缩器，上游分段发送的内容不会滞留在压缩缓冲区中
+func encodeBody(src io.ReadCloser, encoding string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		enc := acquireEncoder(pw, encoding)
+		err := copyFlushing(enc, src)
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		releaseEncoder(encoding, enc)
+		src.Close()
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// copyFlushing 将 src 复制到压缩写入器，每次写入后刷新
+func copyFlushing(enc io.WriteCloser, src io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := enc.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if f, ok := enc.(interface{ Flush() error }); ok {
+				if ferr := f.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resettableWriter 可以通过 Reset 切换输出目标并复用的压缩写入器
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// encoderPools 各编码的压缩写入器池，避免每个响应都重新分配压缩所需的内存
+var encoderPools = map[string]*sync.Pool{
+	"gzip":    {New: func() any { return gzip.NewWriter(nil) }},
+	"deflate": {New: func() any { return zlib.NewWriter(nil) }},
+	"br":      {New: func() any { return brotli.NewWriterLevel(nil, brotliLevel) }},
+	"zstd": {New: func() any {
+		// 每个响应由单独的协程压缩，编码器无需并发，选项均有效时不会返回错误
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return enc
+	}},
+}
+
+// acquireEncoder 从池中获取写入 w 的压缩写入器，不支持的编码原样写入
+func acquireEncoder(w io.Writer, encoding string) io.WriteCloser {
+	pool, ok := encoderPools[encoding]
+	if !ok {
+		return nopWriteCloser{w}
+	}
+	enc := pool.Get().(resettableWriter)
+	enc.Reset(w)
+	return enc
+}
+
+// releaseEncoder 将已关闭的压缩写入器放回池中
+func releaseEncoder(encoding string, enc io.WriteCloser) {
+	pool, ok := encoderPools[encoding]
+	if !ok {
+		return
+	}
+	rw := enc.(resettableWriter)
+	// 解除对本次响应输出的引用
+	rw.Reset(io.Discard)
+	pool.Put(rw)
+}
+
+// addVary 向 Vary 头追加字段，已存在时不重复添加
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// nopWriteCloser 不做任何关闭操作的写入器
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
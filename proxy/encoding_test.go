@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestEncodeBodyRoundTrip(t *testing.T) {
+	content := strings.Repeat("<p>hello, proxy</p>\n", 2000)
+	for _, encoding := range supportedEncodings {
+		// 连续压缩多次，覆盖从池中复用写入器的情况
+		for i := 0; i < 3; i++ {
+			encoded := encodeBody(io.NopCloser(strings.NewReader(content)), encoding)
+			decoded, ok, err := decodeBody(encoded, encoding)
+			if !ok || err != nil {
+				t.Fatalf("%s 解码失败: %v", encoding, err)
+			}
+			data, err := io.ReadAll(decoded)
+			decoded.Close()
+			if err != nil {
+				t.Fatalf("%s 读取失败: %v", encoding, err)
+			}
+			if string(data) != content {
+				t.Fatalf("%s 第 %d 次压缩后内容不一致", encoding, i+1)
+			}
+		}
+	}
+}
+
+func TestReleasedEncoderRoundTripsAfterReset(t *testing.T) {
+	for _, encoding := range supportedEncodings {
+		var first, second bytes.Buffer
+		enc := acquireEncoder(&first, encoding)
+		io.WriteString(enc, strings.Repeat("first response\n", 1000))
+		enc.Close()
+		releaseEncoder(encoding, enc)
+
+		// 放回池中的写入器切换输出后应产生完整独立的压缩流
+		content := strings.Repeat("<p>second response</p>\n", 1000)
+		enc.(resettableWriter).Reset(&second)
+		io.WriteString(enc, content)
+		if err := enc.Close(); err != nil {
+			t.Fatalf("%s 关闭失败: %v", encoding, err)
+		}
+
+		decoded, ok, err := decodeBody(io.NopCloser(&second), encoding)
+		if !ok || err != nil {
+			t.Fatalf("%s 解码失败: %v", encoding, err)
+		}
+		data, err := io.ReadAll(decoded)
+		decoded.Close()
+		if err != nil || string(data) != content {
+			t.Fatalf("%s 复用的写入器输出内容不一致: %v", encoding, err)
+		}
+	}
+}
+
+func TestEncodeBodyFlushesEachChunk(t *testing.T) {
+	const chunk = "<html><head><title>t</title></head>\n"
+	for _, encoding := range supportedEncodings {
+		// 上游发送第一块后停顿，第一块应能立即解压出来
+		src, upstream := io.Pipe()
+		go io.WriteString(upstream, chunk)
+
+		got := make(chan string, 1)
+		go func() {
+			decoded, ok, err := decodeBody(encodeBody(src, encoding), encoding)
+			if !ok || err != nil {
+				got <- ""
+				return
+			}
+			buf := make([]byte, len(chunk))
+			n, _ := io.ReadFull(decoded, buf)
+			got <- string(buf[:n])
+		}()
+
+		select {
+		case data := <-got:
+			if data != chunk {
+				t.Errorf("%s 第一块内容不一致: %q", encoding, data)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s 上游停顿时第一块仍滞留在压缩器中", encoding)
+		}
+		upstream.Close()
+	}
+}
+
+func TestDecodeZstdRejectsLargeWindow(t *testing.T) {
+	content := "<p>hello</p>"
+	// 手工构造的帧：不带内容长度，帧头声明 32 MiB 的窗口，内容为一个原样存储的块
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 15 << 3}
+	header := len(content)<<3 | 1
+	frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+	frame = append(frame, content...)
+
+	// 同样的块在小窗口的帧中可以正常解码
+	small := bytes.Clone(frame)
+	small[5] = 10 << 3
+	decoded, ok, err := decodeBody(io.NopCloser(bytes.NewReader(small)), "zstd")
+	if !ok || err != nil {
+		t.Fatalf("zstd 解码失败: %v", err)
+	}
+	data, err := io.ReadAll(decoded)
+	decoded.Close()
+	if err != nil || string(data) != content {
+		t.Fatalf("1 MiB 窗口的帧应正常解码，实际 %q %v", data, err)
+	}
+
+	decoded, _, err = decodeBody(io.NopCloser(bytes.NewReader(frame)), "zstd")
+	if err == nil {
+		data, err = io.ReadAll(decoded)
+		decoded.Close()
+	}
+	if !errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		t.Fatalf("窗口超过 %d 的帧应返回错误，实际 %q %v", zstdMaxWindow, data, err)
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientInfo 解析后的客户端信息
+type clientInfo struct {
+	ip      string // 客户端真实IP
+	scheme  string // 客户端访问使用的协议
+	host    string // 客户端访问的域名
+	peer    string // 直接连接本服务的地址
+	trusted bool   // 直接连接本服务的地址是否为受信任的代理
+}
+
+// SetTrustedProxies 设置受信任的前置代理网段
+func (pm *ProxyManager) SetTrustedProxies(prefixes []netip.Prefix) {
+	pm.trustedProxies = prefixes
+}
+
+// isTrusted 判断地址是否属于受信任的代理
+func (pm *ProxyManager) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range pm.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientInfo 解析客户端信息
+// 只有直接连接来自受信任的代理时，才采信 Forwarded、X-Forwarded-For 等头，避免客户端伪造
+func (pm *ProxyManager) clientInfo(r *http.Request) clientInfo {
+	peer := remoteIP(r)
+	info := clientInfo{ip: peer, scheme: connScheme(r), host: r.Host, peer: peer}
+	if !pm.isTrusted(peer) {
+		return info
+	}
+	info.trusted = true
+
+	// 优先使用标准的 Forwarded 头，其次是 X-Forwarded-*
+	// protos 和 hosts 与 chain 一样按经过代理的顺序排列，从右侧对齐
+	var chain, protos, hosts []string
+	if elements := parseForwarded(r.Header.Values("Forwarded")); len(elements) > 0 {
+		for _, e := range elements {
+			chain = append(chain, e["for"])
+			protos = append(protos, e["proto"])
+			hosts = append(hosts, e["host"])
+		}
+	} else {
+		chain = splitHeaderList(r.Header.Values("X-Forwarded-For"))
+		if len(chain) == 0 {
+			if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+				chain = append(chain, xri)
+			}
+		}
+		protos = splitHeaderList(r.Header.Values("X-Forwarded-Proto"))
+		hosts = splitHeaderList(r.Header.Values("X-Forwarded-Host"))
+	}
+
+	// 从右向左跳过受信任的代理，第一个不受信任的地址即为客户端
+	hop := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop = len(chain) - 1 - i
+		ip := normalizeNodeIP(chain[i])
+		if ip == "" {
+			break
+		}
+		info.ip = ip
+		if !pm.isTrusted(ip) {
+			break
+		}
+	}
+
+	// 协议和域名取自最靠近客户端的受信任代理添加的一项，更靠左的项可能由客户端伪造
+	if proto := strings.ToLower(fromRight(protos, hop)); proto == "http" || proto == "https" {
+		info.scheme = proto
+	}
+	if host := fromRight(hosts, hop); host != "" {
+		info.host = host
+	}
+	return info
+}
+
+// splitHeaderList 拆分逗号分隔的多值请求头，保留空项以便与其他头按位置对齐
+func splitHeaderList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// fromRight 获取列表中从右数第 n 项（从 0 开始），列表较短时取最左侧一项
+// 部分代理直接覆盖而不是追加 X-Forwarded-Proto 等头，此时列表比 X-Forwarded-For 短
+func fromRight(list []string, n int) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[max(len(list)-1-n, 0)]
+}
+
+// forwardHeaders 设置转发到上游的 X-Forwarded-For 和 Forwarded 头
+// 来自受信任代理的请求在原有值后追加本次连接，否则丢弃客户端传来的值
+func (info clientInfo) forwardHeaders(req *http.Request, r *http.Request) {
+	xff := info.peer
+	element := "for=" + forwardedNode(info.peer) + ";host=" + quoteForwarded(r.Host) + ";proto=" + connScheme(r)
+	if info.trusted {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			xff = strings.Join(prior, ", ") + ", " + xff
+		}
+		if prior := r.Header.Values("Forwarded"); len(prior) > 0 {
+			element = strings.Join(prior, ", ") + ", " + element
+		}
+	}
+	req.Header.Set("X-Forwarded-For", xff)
+	req.Header.Set("Forwarded", element)
+}
+
+// remoteIP 获取直接连接本服务的地址
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// connScheme 根据连接本身判断协议
+func connScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// parseForwarded 解析 RFC 7239 Forwarded 头，返回按顺序排列的各节点参数（键为小写）
+func parseForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			params := make(map[string]string)
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				value = strings.TrimSpace(value)
+				if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+				}
+				params[strings.ToLower(strings.TrimSpace(key))] = value
+			}
+			if len(params) > 0 {
+				elements = append(elements, params)
+			}
+		}
+	}
+	return elements
+}
+
+// splitQuoted 按分隔符拆分，忽略引号内的分隔符
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// normalizeNodeIP 将 Forwarded 的 for 参数或 X-Forwarded-For 中的地址规范化为IP，无法识别时返回空字符串
+func normalizeNodeIP(node string) string {
+	node = strings.TrimSpace(node)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().String()
+}
+
+// forwardedNode 生成 Forwarded 头中的节点，IPv6 地址需要加方括号和引号
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded 按需为 Forwarded 参数值加引号
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientInfoIgnoresSpoofedElements(t *testing.T) {
+	pm := &ProxyManager{}
+	pm.SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		ip      string
+		scheme  string
+		host    string
+	}{
+		{
+			name:    "Forwarded 最左侧伪造的项",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"Forwarded": "for=1.1.1.1;proto=https;host=evil.com, for=203.0.113.5;proto=http;host=example.com"},
+			ip:      "203.0.113.5", scheme: "http", host: "example.com",
+		},
+		{
+			name:    "经过多个受信任代理",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"Forwarded": "for=1.1.1.1;proto=http;host=evil.com, for=203.0.113.5;proto=https;host=example.com, for=10.0.0.2;proto=http;host=internal"},
+			ip:      "203.0.113.5", scheme: "https", host: "example.com",
+		},
+		{
+			name:   "X-Forwarded-* 最左侧伪造的值",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For":   "1.1.1.1, 203.0.113.5",
+				"X-Forwarded-Proto": "https, http",
+				"X-Forwarded-Host":  "evil.com, example.com",
+			},
+			ip: "203.0.113.5", scheme: "http", host: "example.com",
+		},
+		{
+			name:   "代理覆盖而不是追加 X-Forwarded-Proto",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.5, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+			},
+			ip: "203.0.113.5", scheme: "https", host: "proxy.local",
+		},
+		{
+			name:    "不受信任的连接",
+			remote:  "203.0.113.9:1234",
+			headers: map[string]string{"Forwarded": "for=1.1.1.1;proto=https;host=evil.com"},
+			ip:      "203.0.113.9", scheme: "http", host: "proxy.local",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.local/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			info := pm.clientInfo(r)
+			if info.ip != tt.ip || info.scheme != tt.scheme || info.host != tt.host {
+				t.Fatalf("应解析为 %s %s %s，实际 %s %s %s", tt.ip, tt.scheme, tt.host, info.ip, info.scheme, info.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC 状态码，见 https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcCanceled         = 1
+	grpcDeadlineExceeded = 4
+	grpcUnimplemented    = 12
+	grpcUnavailable      = 14
+)
+
+// IsGRPCRequest 判断是否为 gRPC 请求（包括 application/grpc+proto 等子类型，不含 gRPC-Web）
+func IsGRPCRequest(r *http.Request) bool {
+	mt := mediaType(r.Header.Get("Content-Type"))
+	return mt == "application/grpc" || strings.HasPrefix(mt, "application/grpc+")
+}
+
+// grpcStatusFromError 将代理错误映射为 gRPC 状态码
+func grpcStatusFromError(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return grpcCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return grpcDeadlineExceeded
+	}
+	return grpcUnavailable
+}
+
+// writeGRPCError 以 Trailers-Only 形式返回 gRPC 错误
+// gRPC 客户端只识别 grpc-status，HTTP 错误页会被当作协议错误
+func writeGRPCError(w http.ResponseWriter, code int, message string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", encodeGRPCMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage 按 gRPC 规范对 grpc-message 做百分号编码
+func encodeGRPCMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"go_proxy_every/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newH2CServer 启动接受明文 HTTP/2 的测试服务
+func newH2CServer(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// grpcCall 通过明文 HTTP/2 发送 gRPC 形式的请求，返回读完响应体后的响应
+func grpcCall(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	// 长度前缀消息：1 字节压缩标志 + 4 字节长度 + 消息内容
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte{0, 0, 0, 0, 2, 'h', 'i'}))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	return resp, body
+}
+
+// grpcStatus 获取 gRPC 状态，Trailers-Only 响应的状态在响应头中
+func grpcStatus(resp *http.Response) (string, string) {
+	if s := resp.Trailer.Get("Grpc-Status"); s != "" {
+		return s, resp.Trailer.Get("Grpc-Message")
+	}
+	return resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+}
+
+// newGRPCProxy 启动代理到 target 的 h2c 代理服务
+func newGRPCProxy(t *testing.T, target string) *httptest.Server {
+	t.Helper()
+	rules := []config.ProxyRule{{
+		ID: "grpc", Name: "grpc", Path: "/echo.Echo", KeepPrefix: true, Target: target,
+		Transport: config.TransportConfig{HTTP2: HTTP2H2C},
+	}}
+	pm := newTestProxyManager(rules)
+	return newH2CServer(t, pm)
+}
+
+func TestGRPCTrailersPassThrough(t *testing.T) {
+	upstream := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Te") != "trailers" {
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Grpc-Status", "13")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Echo-Size")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "user%20not%20found")
+		w.Header().Set("X-Echo-Size", strconv.Itoa(len(data)))
+	}))
+	proxy := newGRPCProxy(t, upstream.URL)
+
+	resp, body := grpcCall(t, proxy.URL+"/echo.Echo/Say")
+	if resp.ProtoMajor != 2 || !bytes.Equal(body, []byte{0, 0, 0, 0, 2, 'h', 'i'}) {
+		t.Fatalf("响应协议或内容错误: %s %q", resp.Proto, body)
+	}
+	if status, msg := grpcStatus(resp); status != "5" || msg != "user%20not%20found" {
+		t.Fatalf("grpc-status/grpc-message 尾部应透传，实际 %q %q", status, msg)
+	}
+	if size := resp.Trailer.Get("X-Echo-Size"); size != "7" {
+		t.Fatalf("自定义尾部应透传，实际 %q", size)
+	}
+}
+
+func TestGRPCProxyErrors(t *testing.T) {
+	// 上游地址无法连接
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + ln.Addr().String()
+	ln.Close()
+	proxy := newGRPCProxy(t, closed)
+
+	tests := []struct {
+		path   string
+		status string
+	}{
+		{"/echo.Echo/Say", strconv.Itoa(grpcUnavailable)},
+		{"/other.Service/Call", strconv.Itoa(grpcUnimplemented)},
+	}
+	for _, tt := range tests {
+		resp, _ := grpcCall(t, proxy.URL+tt.path)
+		status, msg := grpcStatus(resp)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" || status != tt.status || msg == "" {
+			t.Fatalf("%s 应返回 grpc-status %s，实际 %d %q %q", tt.path, tt.status, resp.StatusCode, status, msg)
+		}
+	}
+}
+
+func TestH2CTransportSettings(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstream := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo.Echo/Slow" {
+			select {
+			case <-release:
+			case <-time.After(3 * time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "0")
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	pm := newTestProxyManager([]config.ProxyRule{{
+		ID: "grpc", Name: "grpc", Path: "/echo.Echo", KeepPrefix: true, Target: upstream.URL,
+		Transport: config.TransportConfig{HTTP2: HTTP2H2C, ResponseHeaderTimeout: 1, DisableKeepAlives: true},
+	}})
+	proxy := newH2CServer(t, pm)
+
+	// 超过 response_header_timeout 未收到响应头
+	start := time.Now()
+	resp, _ := grpcCall(t, proxy.URL+"/echo.Echo/Slow")
+	if status, _ := grpcStatus(resp); status != strconv.Itoa(grpcDeadlineExceeded) {
+		t.Fatalf("等待响应头超时应返回 grpc-status %d，实际 %q", grpcDeadlineExceeded, status)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("应在 1 秒后超时，实际等待 %v", elapsed)
+	}
+
+	// disable_keep_alives 时每个请求使用新连接
+	_, first := grpcCall(t, proxy.URL+"/echo.Echo/Say")
+	_, second := grpcCall(t, proxy.URL+"/echo.Echo/Say")
+	if len(first) == 0 || bytes.Equal(first, second) {
+		t.Fatalf("关闭 keep-alive 后不应复用连接，实际 %q %q", first, second)
+	}
+
+	// max_conns_per_host 限制 h2c 上游只使用一个连接
+	tr := newTransport(config.TransportConfig{HTTP2: HTTP2H2C, MaxConnsPerHost: 4}, nil).(*h2cTransport)
+	if !tr.h2c.StrictMaxConcurrentStreams {
+		t.Fatal("设置 max_conns_per_host 后不应为并发流新建连接")
+	}
+}
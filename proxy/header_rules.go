@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go_proxy_every/config"
+	"net/http"
+	"strings"
+)
+
+// 请求头和响应头的修改方式
+const (
+	HeaderSet    = "set"
+	HeaderAdd    = "add"
+	HeaderRemove = "remove"
+)
+
+// HeaderActions 支持的头修改方式
+var HeaderActions = []string{HeaderSet, HeaderAdd, HeaderRemove}
+
+// defaultRequestHeaders 转发到上游时默认设置的请求头，规则中的请求头修改在其后执行，可以覆盖或删除
+var defaultRequestHeaders = []config.HeaderRule{
+	{Action: HeaderSet, Name: "X-Forwarded-Host", Value: "{host}"},
+	{Action: HeaderSet, Name: "X-Forwarded-Proto", Value: "{scheme}"},
+	{Action: HeaderSet, Name: "X-Real-IP", Value: "{client_ip}"},
+}
+
+// headerVars 头的值中可以使用的变量
+func (s *proxyState) headerVars() *strings.Replacer {
+	return strings.NewReplacer(
+		"{client_ip}", s.client.ip,
+		"{host}", s.client.host,
+		"{scheme}", s.client.scheme,
+		"{rule_name}", s.route.rule.Name,
+		"{request_id}", s.requestID,
+	)
+}
+
+// applyHeaderRules 按顺序执行头修改规则
+func applyHeaderRules(h http.Header, rules []config.HeaderRule, vars *strings.Replacer) {
+	for _, rule := range rules {
+		name := http.CanonicalHeaderKey(strings.TrimSpace(rule.Name))
+		if name == "" {
+			continue
+		}
+		switch strings.ToLower(rule.Action) {
+		case HeaderSet:
+			h.Set(name, vars.Replace(rule.Value))
+		case HeaderAdd:
+			h.Add(name, vars.Replace(rule.Value))
+		case HeaderRemove:
+			h.Del(name)
+		}
+	}
+}
+
+// applyRequestHeaderRules 修改转发到上游的请求头，设置 Host 头时改写请求的 Host
+func applyRequestHeaderRules(req *http.Request, rules []config.HeaderRule, vars *strings.Replacer) {
+	applyHeaderRules(req.Header, rules, vars)
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+}
+
+// requestID 获取请求ID，客户端未提供 X-Request-ID 时随机生成
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
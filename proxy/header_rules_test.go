@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"encoding/json"
+	"go_proxy_every/config"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// forwardedHeaders 通过代理发送请求，返回上游收到的请求头
+func forwardedHeaders(t *testing.T, rules []config.HeaderRule, trusted bool, headers map[string]string) http.Header {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Host", r.Host)
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer upstream.Close()
+
+	pm := newTestProxyManager([]config.ProxyRule{{
+		ID: "h", Name: "h", Path: "/h", Target: upstream.URL, RequestHeaders: rules,
+	}})
+	if trusted {
+		pm.SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.local/h/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	pm.ServeHTTP(w, r)
+
+	var got http.Header
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("解析上游收到的请求头失败: %v", err)
+	}
+	return got
+}
+
+func TestRequestHeaderRulesForwardedFor(t *testing.T) {
+	spoofed := map[string]string{"X-Forwarded-For": "1.1.1.1"}
+	tests := []struct {
+		name    string
+		rules   []config.HeaderRule
+		trusted bool
+		want    []string
+	}{
+		{"默认为直接连接的地址", nil, false, []string{"10.0.0.1"}},
+		{"受信任代理时追加", nil, true, []string{"1.1.1.1, 10.0.0.1"}},
+		{"删除", []config.HeaderRule{{Action: HeaderRemove, Name: "X-Forwarded-For"}}, true, nil},
+		{"设置", []config.HeaderRule{{Action: HeaderSet, Name: "x-forwarded-for", Value: "{client_ip}"}}, true, []string{"1.1.1.1"}},
+		{"追加", []config.HeaderRule{{Action: HeaderAdd, Name: "X-Forwarded-For", Value: "192.0.2.9"}}, false, []string{"10.0.0.1", "192.0.2.9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := forwardedHeaders(t, tt.rules, tt.trusted, spoofed)["X-Forwarded-For"]
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Fatalf("X-Forwarded-For 应为 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestHeaderRulesOverrideDefaults(t *testing.T) {
+	rules := []config.HeaderRule{
+		{Action: HeaderRemove, Name: "X-Real-IP"},
+		{Action: HeaderRemove, Name: "Forwarded"},
+		{Action: HeaderSet, Name: "X-Forwarded-Proto", Value: "https"},
+		{Action: HeaderSet, Name: "Host", Value: "backend.internal"},
+	}
+	got := forwardedHeaders(t, rules, false, map[string]string{"X-Forwarded-Host": "evil.com"})
+
+	for _, name := range []string{"X-Real-Ip", "Forwarded"} {
+		if v, ok := got[name]; ok {
+			t.Errorf("%s 应被删除，实际 %q", name, v)
+		}
+	}
+	if v := got.Get("X-Forwarded-Proto"); v != "https" {
+		t.Errorf("X-Forwarded-Proto 应为 https，实际 %q", v)
+	}
+	if v := got.Get("Host"); v != "backend.internal" {
+		t.Errorf("Host 应为 backend.internal，实际 %q", v)
+	}
+	if v := got.Get("X-Forwarded-Host"); v != "proxy.local" {
+		t.Errorf("X-Forwarded-Host 应为代理域名，实际 %q", v)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rewriteResponseHeaders 将响应头中指向上游的地址映射回代理路径
+// 处理重定向及相关头：Location、Content-Location、Refresh、Link
+func rewriteResponseHeaders(h http.Header, links *linkRewriter) {
+	for _, name := range []string{"Location", "Content-Location"} {
+		if v := h.Get(name); v != "" {
+			if rewritten, ok := links.rewriteWithRoot(v); ok {
+				h.Set(name, rewritten)
+			}
+		}
+	}
+
+	if v := h.Get("Refresh"); v != "" {
+		if rewritten, ok := rewriteRefresh(v, links); ok {
+			h.Set("Refresh", rewritten)
+		}
+	}
+
+	if values := h.Values("Link"); len(values) > 0 {
+		rewritten := make([]string, len(values))
+		for i, v := range values {
+			rewritten[i] = rewriteLinkHeader(v, links)
+		}
+		h["Link"] = rewritten
+	}
+}
+
+// rewriteRefresh 重写 Refresh 头，格式如 5; url=https://www.nsmao.com/login
+func rewriteRefresh(value string, links *linkRewriter) (string, bool) {
+	delay, rest, ok := strings.Cut(value, ";")
+	if !ok {
+		return value, false
+	}
+
+	rest = strings.TrimSpace(rest)
+	key, target, ok := strings.Cut(rest, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(key), "url") {
+		return value, false
+	}
+
+	target = strings.TrimSpace(target)
+	quote := ""
+	if len(target) >= 2 && (target[0] == '\'' || target[0] == '"') && target[len(target)-1] == target[0] {
+		quote = target[:1]
+		target = target[1 : len(target)-1]
+	}
+
+	rewritten, ok := links.rewriteWithRoot(target)
+	if !ok {
+		return value, false
+	}
+	return delay + "; url=" + quote + rewritten + quote, true
+}
+
+// rewriteLinkHeader 重写 Link 头中尖括号内的地址，如 </style.css>; rel=preload
+func rewriteLinkHeader(value string, links *linkRewriter) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		sb.WriteString(value[:start+1])
+		target := value[start+1 : end]
+		if rewritten, ok := links.rewriteWithRoot(target); ok {
+			target = rewritten
+		}
+		sb.WriteString(target)
+		sb.WriteByte('>')
+		value = value[end+1:]
+	}
+	sb.WriteString(value)
+	return sb.String()
+}
+
+// weakenETag 将强 ETag 改为弱 ETag，If-Range 只接受强 ETag，客户端不会再按字节范围拼接改写前后的内容
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
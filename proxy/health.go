@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"go_proxy_every/config"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 健康检查默认值
+const (
+	defaultHealthPath               = "/"
+	defaultHealthInterval           = 10 * time.Second
+	defaultHealthTimeout            = 5 * time.Second
+	defaultHealthUnhealthyThreshold = 3
+	defaultHealthHealthyThreshold   = 2
+)
+
+// healthState 上游健康状态
+type healthState struct {
+	mu        sync.Mutex
+	healthy   bool
+	failures  int // 连续失败次数
+	successes int // 连续成功次数
+	lastCheck time.Time
+	lastError string
+	downSince time.Time
+	lastRetry time.Time
+}
+
+// UpstreamStatus 上游状态
+type UpstreamStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+	DownSince time.Time `json:"down_since,omitempty"`
+	Active    int64     `json:"active"`
+}
+
+// RuleHealth 规则的上游健康状态
+type RuleHealth struct {
+	RuleID      string           `json:"rule_id"`
+	RuleName    string           `json:"rule_name"`
+	HealthCheck bool             `json:"health_check"`
+	Passive     bool             `json:"passive"`
+	Upstreams   []UpstreamStatus `json:"upstreams"`
+}
+
+// healthChecker 健康检查器
+type healthChecker struct {
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	expectedStatus     int
+	unhealthyThreshold int
+	healthyThreshold   int
+	active             bool // 主动探测
+	passive            bool // 根据代理结果被动判定
+
+	client *http.Client
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// newHealthChecker 根据配置创建健康检查器，未启用时返回 nil
+func newHealthChecker(cfg config.HealthCheck, transport http.RoundTripper) *healthChecker {
+	if !cfg.Enabled && !cfg.Passive {
+		return nil
+	}
+
+	hc := &healthChecker{
+		path:               cfg.Path,
+		interval:           time.Duration(cfg.Interval) * time.Second,
+		timeout:            time.Duration(cfg.Timeout) * time.Second,
+		expectedStatus:     cfg.ExpectedStatus,
+		unhealthyThreshold: cfg.UnhealthyThreshold,
+		healthyThreshold:   cfg.HealthyThreshold,
+		active:             cfg.Enabled,
+		passive:            cfg.Passive,
+		stop:               make(chan struct{}),
+	}
+	if hc.path == "" {
+		hc.path = defaultHealthPath
+	}
+	if hc.interval <= 0 {
+		hc.interval = defaultHealthInterval
+	}
+	if hc.timeout <= 0 {
+		hc.timeout = defaultHealthTimeout
+	}
+	if hc.unhealthyThreshold <= 0 {
+		hc.unhealthyThreshold = defaultHealthUnhealthyThreshold
+	}
+	if hc.healthyThreshold <= 0 {
+		hc.healthyThreshold = defaultHealthHealthyThreshold
+	}
+	hc.client = &http.Client{
+		Transport: transport,
+		Timeout:   hc.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return hc
+}
+
+// run 定期探测所有上游，直到 close 被调用
+func (hc *healthChecker) run(upstreams []*upstream) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, u := range upstreams {
+			go hc.probe(u)
+		}
+
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe 探测单个上游
+func (hc *healthChecker) probe(u *upstream) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	defer cancel()
+
+	target := *u.url
+	target.Path = singleJoiningSlash(u.url.Path, hc.path)
+	target.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		hc.reportFailure(u, err.Error())
+		return
+	}
+	req.Header.Set("User-Agent", "go_proxy_every-health-check")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		hc.reportFailure(u, err.Error())
+		return
+	}
+	resp.Body.Close()
+
+	if !hc.statusOK(resp.StatusCode) {
+		hc.reportFailure(u, fmt.Sprintf("unexpected status %d", resp.StatusCode))
+		return
+	}
+	hc.reportSuccess(u)
+}
+
+// statusOK 判断探测返回的状态码是否符合预期
+func (hc *healthChecker) statusOK(status int) bool {
+	if hc.expectedStatus != 0 {
+		return status == hc.expectedStatus
+	}
+	return status >= 200 && status < 400
+}
+
+// reportFailure 记录一次失败，连续失败达到阈值后标记为不健康
+func (hc *healthChecker) reportFailure(u *upstream, reason string) {
+	h := &u.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCheck = time.Now()
+	h.lastError = reason
+	h.successes = 0
+	h.failures++
+
+	if h.healthy && h.failures >= hc.unhealthyThreshold {
+		h.healthy = false
+		h.downSince = time.Now()
+		u.setAvailable(false)
+		log.Printf("[Health] 上游 %s 已标记为不健康: %s", u.url, reason)
+	}
+}
+
+// reportSuccess 记录一次成功，连续成功达到阈值后恢复
+func (hc *healthChecker) reportSuccess(u *upstream) {
+	h := &u.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCheck = time.Now()
+	h.failures = 0
+	h.successes++
+
+	if !h.healthy && h.successes >= hc.healthyThreshold {
+		h.healthy = true
+		h.lastError = ""
+		h.downSince = time.Time{}
+		u.setAvailable(true)
+		log.Printf("[Health] 上游 %s 已恢复", u.url)
+	}
+}
+
+// observe 被动健康检查：根据代理结果更新状态
+func (hc *healthChecker) observe(u *upstream, status int, err error) {
+	if hc == nil || !hc.passive {
+		return
+	}
+
+	switch {
+	case err != nil:
+		hc.reportFailure(u, err.Error())
+	case status >= 500:
+		hc.reportFailure(u, fmt.Sprintf("upstream returned %d", status))
+	default:
+		hc.reportSuccess(u)
+	}
+}
+
+// retryable 仅被动检查时，不健康的上游在一个探测间隔后重新放行一个请求用于判断是否恢复
+func (hc *healthChecker) retryable(u *upstream) bool {
+	if hc == nil || hc.active {
+		return false
+	}
+
+	h := &u.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.downSince) < hc.interval || time.Since(h.lastRetry) < hc.interval {
+		return false
+	}
+	h.lastRetry = time.Now()
+	return true
+}
+
+// close 停止主动探测
+func (hc *healthChecker) close() {
+	if hc == nil {
+		return
+	}
+	hc.once.Do(func() {
+		close(hc.stop)
+	})
+}
+
+// status 获取上游状态快照
+func (u *upstream) status() UpstreamStatus {
+	h := &u.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return UpstreamStatus{
+		URL:       u.url.String(),
+		Healthy:   h.healthy,
+		Failures:  h.failures,
+		LastCheck: h.lastCheck,
+		LastError: h.lastError,
+		DownSince: h.downSince,
+		Active:    u.active.Load(),
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor 等待条件成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	var status atomic.Int32
+	var delay atomic.Int64
+	var probes atomic.Int32
+	status.Store(http.StatusNoContent)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		probes.Add(1)
+		time.Sleep(time.Duration(delay.Load()))
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stable.Close()
+
+	b := newBalancer(config.ProxyRule{
+		Name:      "health",
+		Upstreams: []config.Upstream{{URL: flaky.URL + "/app"}, {URL: stable.URL}},
+	}, nil)
+	// 直接创建检查器以使用毫秒级的探测间隔和超时
+	hc := newHealthChecker(config.HealthCheck{
+		Enabled: true, Path: "/healthz", ExpectedStatus: http.StatusNoContent,
+		UnhealthyThreshold: 2, HealthyThreshold: 3,
+	}, http.DefaultTransport)
+	hc.interval = 10 * time.Millisecond
+	hc.timeout = 50 * time.Millisecond
+	hc.client.Timeout = hc.timeout
+	b.health = hc
+	go hc.run(b.upstreams)
+	defer b.close()
+
+	up := b.upstreams[0]
+	waitFor(t, "探测到上游路径下的检查地址", func() bool { return probes.Load() > 0 })
+	if !up.available.Load() {
+		t.Fatal("符合 expected_status 的上游应保持可用")
+	}
+
+	// 2xx 但不是 expected_status 也视为失败，连续失败达到阈值后移出轮询
+	status.Store(http.StatusOK)
+	waitFor(t, "上游被标记为不健康", func() bool { return !up.available.Load() })
+	if s := up.status(); s.Healthy || s.Failures < 2 || !strings.Contains(s.LastError, "unexpected status 200") {
+		t.Fatalf("不健康的上游状态不正确: %+v", s)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < 10; i++ {
+		if b.pick(r, "") == up {
+			t.Fatal("不健康的上游不应参与负载均衡")
+		}
+	}
+
+	// 连续成功达到阈值后重新加入轮询
+	status.Store(http.StatusNoContent)
+	before := probes.Load()
+	waitFor(t, "上游恢复", func() bool { return up.available.Load() })
+	if n := probes.Load() - before; n < 3 {
+		t.Fatalf("应在连续 3 次成功后恢复，实际 %d 次探测", n)
+	}
+	if s := up.status(); !s.Healthy || s.LastError != "" || !s.DownSince.IsZero() {
+		t.Fatalf("恢复后的上游状态不正确: %+v", s)
+	}
+
+	// 超过探测超时同样视为失败
+	delay.Store(int64(200 * time.Millisecond))
+	waitFor(t, "超时的上游被标记为不健康", func() bool { return !up.available.Load() })
+	if !b.upstreams[1].available.Load() {
+		t.Fatal("正常的上游不应受影响")
+	}
+}
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"go_proxy_every/config"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// urlAttrs 需要重写的链接属性
+var urlAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"data":       true,
+	"cite":       true,
+	"background": true,
+	"longdesc":   true,
+	"manifest":   true,
+	"ping":       true,
+}
+
+// srcsetAttrs 以 srcset 语法表示的属性
+var srcsetAttrs = map[string]bool{
+	"srcset":      true,
+	"imagesrcset": true,
+}
+
+// htmlFlushSize 重写结果攒到该大小时立即输出，与 ReverseProxy 的复制缓冲区大小一致
+const htmlFlushSize = 32 << 10
+
+// htmlRewriter 基于词法分析的流式HTML重写器
+// 逐个读取标签并改写链接属性，不需要缓冲整个响应体
+type htmlRewriter struct {
+	body      io.ReadCloser
+	src       *bufio.Reader
+	z         *html.Tokenizer
+	links     *linkRewriter
+	opts      config.RewriteOptions
+	inject    config.HTMLInjection
+	namespace string // Cookie 命名空间，传给客户端脚本
+	buf       bytes.Buffer
+	err       error
+	pr        *io.PipeReader
+	pw        *io.PipeWriter
+
+	rawTag   string // 当前所在的 script/style 元素，其文本内容需要按 JS/CSS 处理
+	injected bool   // 是否已注入客户端重写脚本
+	headDone bool   // 是否已在 </head> 前注入片段
+	bodyDone bool   // 是否已在 </body> 前注入片段
+}
+
+// newHTMLRewriter 创建流式HTML重写器，在单独的协程中读取上游并重写
+func newHTMLRewriter(src io.ReadCloser, links *linkRewriter, opts config.RewriteOptions, inject config.HTMLInjection, namespace string) *htmlRewriter {
+	pr, pw := io.Pipe()
+	h := &htmlRewriter{
+		body:      src,
+		src:       bufio.NewReaderSize(src, htmlFlushSize),
+		links:     links,
+		opts:      opts,
+		inject:    inject,
+		namespace: namespace,
+		pr:        pr,
+		pw:        pw,
+	}
+	h.z = html.NewTokenizer(htmlSource{h})
+	go h.run()
+	return h
+}
+
+// Read 实现 io.Reader，返回已重写的内容
+func (h *htmlRewriter) Read(p []byte) (int, error) {
+	return h.pr.Read(p)
+}
+
+// Close 停止重写，上游响应体由重写协程关闭
+func (h *htmlRewriter) Close() error {
+	return h.pr.Close()
+}
+
+// run 逐个处理词法单元直到上游结束或读取方关闭
+func (h *htmlRewriter) run() {
+	for h.err == nil {
+		h.next()
+		if h.buf.Len() >= htmlFlushSize {
+			if err := h.flush(); err != nil {
+				h.err = err
+			}
+		}
+	}
+	if err := h.flush(); err != nil && h.err == io.EOF {
+		h.err = err
+	}
+	h.body.Close()
+	h.pw.CloseWithError(h.err)
+}
+
+// flush 输出已重写的内容，阻塞到读取方取走为止
+func (h *htmlRewriter) flush() error {
+	if h.buf.Len() == 0 {
+		return nil
+	}
+	_, err := h.pw.Write(h.buf.Bytes())
+	h.buf.Reset()
+	return err
+}
+
+// htmlSource 词法分析器的输入
+// 需要等待上游数据时先输出已重写的内容，上游分段发送时客户端能立即收到已完成的部分
+type htmlSource struct {
+	h *htmlRewriter
+}
+
+func (s htmlSource) Read(p []byte) (int, error) {
+	if s.h.src.Buffered() == 0 {
+		if err := s.h.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return s.h.src.Read(p)
+}
+
+// next 处理下一个词法单元
+func (h *htmlRewriter) next() {
+	tt := h.z.Next()
+
+	// Raw 返回的切片在下次调用 TagName/TagAttr 后可能失效，先写入缓冲区
+	start := h.buf.Len()
+	h.buf.Write(h.z.Raw())
+
+	switch tt {
+	case html.ErrorToken:
+		h.err = h.z.Err()
+	case html.StartTagToken, html.SelfClosingTagToken:
+		name, tag, ok := h.rewriteTag(tt)
+		if ok {
+			h.buf.Truncate(start)
+			h.buf.WriteString(tag)
+		}
+		if tt == html.StartTagToken && (name == "script" || name == "style") {
+			h.rawTag = name
+		}
+		h.injectShim(name, start)
+	case html.EndTagToken:
+		h.rawTag = ""
+		h.injectSnippet(start)
+	case html.TextToken:
+		if text, ok := h.rewriteText(h.buf.Bytes()[start:]); ok {
+			h.buf.Truncate(start)
+			h.buf.WriteString(text)
+		}
+	}
+}
+
+// injectShim 在 <head> 之后注入客户端重写脚本
+// 页面没有 <head> 时，注入到第一个其他元素之前
+func (h *htmlRewriter) injectShim(name string, start int) {
+	if !h.opts.ClientShim || h.injected || name == "html" {
+		return
+	}
+	h.injected = true
+
+	if name == "head" {
+		h.buf.WriteString(h.links.shimTag(h.namespace))
+		return
+	}
+	tag := string(h.buf.Bytes()[start:])
+	h.buf.Truncate(start)
+	h.buf.WriteString(h.links.shimTag(h.namespace))
+	h.buf.WriteString(tag)
+}
+
+// injectSnippet 在 </head> 和 </body> 之前插入自定义片段，每个位置只插入一次
+// 页面缺少对应的结束标签时不插入
+func (h *htmlRewriter) injectSnippet(start int) {
+	if h.inject.Head == "" && h.inject.Body == "" {
+		return
+	}
+
+	var snippet string
+	name, _ := h.z.TagName()
+	switch string(name) {
+	case "head":
+		if h.headDone {
+			return
+		}
+		h.headDone, snippet = true, h.inject.Head
+	case "body":
+		if h.bodyDone {
+			return
+		}
+		h.bodyDone, snippet = true, h.inject.Body
+	}
+	if snippet == "" {
+		return
+	}
+
+	tag := string(h.buf.Bytes()[start:])
+	h.buf.Truncate(start)
+	h.buf.WriteString(snippet)
+	h.buf.WriteString(tag)
+}
+
+// rewriteText 重写 <style> 和 <script> 中的地址
+func (h *htmlRewriter) rewriteText(raw []byte) (string, bool) {
+	switch {
+	case h.rawTag == "style" && h.opts.InlineStyle:
+		return rewriteCSS(string(raw), h.links, h.opts.RootRelative), true
+	case h.rawTag == "script" && h.opts.JS:
+		return rewriteJS(string(raw), h.links, h.opts.RootRelative), true
+	}
+	return "", false
+}
+
+// rewriteURL 重写属性中的单个地址
+func (h *htmlRewriter) rewriteURL(value string) (string, bool) {
+	if h.opts.RootRelative {
+		return h.links.rewriteWithRoot(value)
+	}
+	return h.links.rewrite(value)
+}
+
+// rewriteTag 重写标签中的链接属性，返回标签名，没有需要改写的属性或关闭了HTML重写时返回 false
+func (h *htmlRewriter) rewriteTag(tt html.TokenType) (string, string, bool) {
+	tagName, hasAttr := h.z.TagName()
+	name := string(tagName)
+	if !hasAttr || h.opts.DisableHTML {
+		return name, "", false
+	}
+
+	type attr struct {
+		key, val string
+	}
+	var attrs []attr
+	changed := false
+	for hasAttr {
+		var key, val []byte
+		key, val, hasAttr = h.z.TagAttr()
+		a := attr{key: string(key), val: string(val)}
+
+		switch {
+		case urlAttrs[a.key]:
+			if v, ok := h.rewriteURL(a.val); ok {
+				a.val, changed = v, true
+			}
+		case srcsetAttrs[a.key]:
+			if v, ok := h.links.rewriteSrcset(a.val, h.opts.RootRelative); ok {
+				a.val, changed = v, true
+			}
+		case a.key == "style" && h.opts.InlineStyle:
+			if v := rewriteCSS(a.val, h.links, h.opts.RootRelative); v != a.val {
+				a.val, changed = v, true
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	if !changed {
+		return name, "", false
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('<')
+	sb.WriteString(name)
+	for _, a := range attrs {
+		sb.WriteByte(' ')
+		sb.WriteString(a.key)
+		sb.WriteString(`="`)
+		sb.WriteString(html.EscapeString(a.val))
+		sb.WriteByte('"')
+	}
+	if tt == html.SelfClosingTagToken {
+		sb.WriteString("/>")
+	} else {
+		sb.WriteByte('>')
+	}
+	return name, sb.String(), true
+}
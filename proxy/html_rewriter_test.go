@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"fmt"
+	"go_proxy_every/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriter 统计写入和刷新次数的 ResponseWriter
+type countingWriter struct {
+	*httptest.ResponseRecorder
+	writes  int
+	flushes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.ResponseRecorder.Write(p)
+}
+
+func (w *countingWriter) Flush() {
+	w.flushes++
+	w.ResponseRecorder.Flush()
+}
+
+func TestHTMLRewriterLargePageFewWrites(t *testing.T) {
+	var page strings.Builder
+	page.WriteString("<html><head><title>t</title></head><body>")
+	for i := 0; page.Len() < 84*1024; i++ {
+		fmt.Fprintf(&page, `<p><a href="/item/%d">item %d</a></p>`, i, i)
+	}
+	page.WriteString("</body></html>")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page.String()))
+	}))
+	defer upstream.Close()
+
+	rules := []config.ProxyRule{{
+		ID: "site", Name: "site", Path: "/site", Target: upstream.URL,
+		Rewriting: config.RewriteOptions{RootRelative: true},
+	}}
+	pm := newTestProxyManager(rules)
+
+	w := &countingWriter{ResponseRecorder: httptest.NewRecorder()}
+	pm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/site/", nil))
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `href="/site/item/0"`) || !strings.HasSuffix(body, "</html>") {
+		t.Fatalf("重写后的页面不完整: %d, %d 字节", w.Code, len(body))
+	}
+	if w.writes > 10 || w.flushes > 10 {
+		t.Fatalf("%d 字节的页面应分少量块写出，实际写入 %d 次，刷新 %d 次", len(body), w.writes, w.flushes)
+	}
+}
+
+func TestHTMLRewriterStreamsBeforeUpstreamPause(t *testing.T) {
+	const head = "<html><head><title>t</title><link rel=\"stylesheet\" href=\"/app.css\"></head>\n"
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, head)
+		w.(http.Flusher).Flush()
+		// 上游生成页面主体前停顿
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+		}
+		io.WriteString(w, "<body><a href=\"/x\">x</a></body></html>")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	rules := []config.ProxyRule{{
+		ID: "site", Name: "site", Path: "/site", Target: upstream.URL,
+		Rewriting: config.RewriteOptions{RootRelative: true},
+	}}
+	proxy := httptest.NewServer(newTestProxyManager(rules))
+	defer proxy.Close()
+
+	// 不请求压缩，只检查重写器本身是否攒住输出
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	start := time.Now()
+	resp, err := client.Get(proxy.URL + "/site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got := make([]byte, 0, len(head))
+	buf := make([]byte, 1024)
+	for !strings.Contains(string(got), "</head>") {
+		n, err := resp.Body.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil && !strings.Contains(string(got), "</head>") {
+			t.Fatalf("读取 <head> 失败: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("上游停顿期间应已收到 <head>，实际等待 %v", elapsed)
+	}
+	if !strings.Contains(string(got), `href="/site/app.css"`) {
+		t.Fatalf("<head> 中的链接应被重写，实际 %s", got)
+	}
+}
+
+// rewriteHTML 使用流式重写器处理整个页面
+func rewriteHTML(t *testing.T, page string, links *linkRewriter, opts config.RewriteOptions, inject config.HTMLInjection) string {
+	t.Helper()
+	h := newHTMLRewriter(io.NopCloser(strings.NewReader(page)), links, opts, inject, "")
+	data, err := io.ReadAll(h)
+	h.Close()
+	if err != nil {
+		t.Fatalf("重写失败: %v", err)
+	}
+	return string(data)
+}
+
+func TestHTMLRewriterAttributes(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	root := config.RewriteOptions{RootRelative: true, InlineStyle: true, JS: true}
+	tests := []struct {
+		name string
+		opts config.RewriteOptions
+		in   string
+		want string
+	}{
+		{"绝对地址", config.RewriteOptions{}, `<a href="http://up/app/x" class="btn">x</a>`, `<a href="/p/x" class="btn">x</a>`},
+		{"未开启根相对地址", config.RewriteOptions{}, `<a href="/app/x">x</a>`, `<a href="/app/x">x</a>`},
+		{"根相对地址", root, `<img src="/app/a.png" alt=a>`, `<img src="/p/a.png" alt="a">`},
+		{"上游路径之外", root, `<img src="/other/a.png" alt=a>`, `<img src="/other/a.png" alt=a>`},
+		{"自闭合标签", root, `<link rel="icon" href="/app/favicon.ico"/>`, `<link rel="icon" href="/p/favicon.ico"/>`},
+		{"表单", root, `<form action="/app/login"><button formaction="/app/x">`, `<form action="/p/login"><button formaction="/p/x">`},
+		{"srcset", root, `<img srcset="/app/a.png 1x, /app/b.png 2x">`, `<img srcset="/p/a.png 1x, /p/b.png 2x">`},
+		{"style 属性", root, `<div style="background:url(/app/bg.png)"></div>`, `<div style="background:url(/p/bg.png)"></div>`},
+		{"属性值转义", root, `<a href="/app/?a=1&amp;b=2" title="&quot;q&quot;">`, `<a href="/p/?a=1&amp;b=2" title="&#34;q&#34;">`},
+		{"style 元素", root, `<style>a{background:url(/app/a.png)}</style>`, `<style>a{background:url(/p/a.png)}</style>`},
+		{"script 元素", root, `<script>fetch("/app/api")</script>`, `<script>fetch("/p/api")</script>`},
+		{"script 中的标签文本", root, `<script>var s = "<a href='/app/x'>";</script>`, `<script>var s = "<a href='/app/x'>";</script>`},
+		{"关闭 HTML 重写", config.RewriteOptions{DisableHTML: true}, `<a href="http://up/app/x">x</a>`, `<a href="http://up/app/x">x</a>`},
+		{"注释保持不变", root, `<!-- <a href="/app/x"> -->`, `<!-- <a href="/app/x"> -->`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteHTML(t, tt.in, links, tt.opts, config.HTMLInjection{}); got != tt.want {
+				t.Fatalf("应重写为\n%s\n实际\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInjectSnippet(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up")
+	inject := config.HTMLInjection{Head: `<style>.x{}</style>`, Body: `<div id="banner"></div>`}
+	tests := []struct {
+		name   string
+		inject config.HTMLInjection
+		in     string
+		want   string
+	}{
+		{
+			"</head> 和 </body>", inject,
+			`<html><head><title>t</title></head><body><p>hi</p></body></html>`,
+			`<html><head><title>t</title><style>.x{}</style></head><body><p>hi</p><div id="banner"></div></body></html>`,
+		},
+		{
+			"只配置 body", config.HTMLInjection{Body: "<i>b</i>"},
+			`<head></head><body></body>`,
+			`<head></head><body><i>b</i></body>`,
+		},
+		{
+			"缺少结束标签时不插入", inject,
+			`<html><head><title>t</title><body><p>hi</p>`,
+			`<html><head><title>t</title><body><p>hi</p>`,
+		},
+		{
+			"重复的 </body> 只插入一次", inject,
+			`<body><p>a</p></body><p>b</p></body>`,
+			`<body><p>a</p><div id="banner"></div></body><p>b</p></body>`,
+		},
+		{
+			"大写的结束标签", inject,
+			`<HEAD></HEAD><BODY></BODY>`,
+			`<HEAD><style>.x{}</style></HEAD><BODY><div id="banner"></div></BODY>`,
+		},
+		{
+			"脚本中的结束标签文本", inject,
+			`<body><script>document.write("</body>")</script></body>`,
+			`<body><script>document.write("</body>")</script><div id="banner"></div></body>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteHTML(t, tt.in, links, config.RewriteOptions{DisableHTML: true}, tt.inject); got != tt.want {
+				t.Fatalf("应输出\n%s\n实际\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInjectSnippetSkipsNonHTML(t *testing.T) {
+	const body = `<head></head><body></body>`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "text/html")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	rules := []config.ProxyRule{{
+		ID: "inject", Name: "inject", Path: "/site", Target: upstream.URL,
+		Rewriting: config.RewriteOptions{DisableHTML: true},
+		Inject:    config.HTMLInjection{Body: "<i>b</i>"},
+	}}
+	pm := newTestProxyManager(rules)
+
+	for path, want := range map[string]string{
+		"/site/page": `<head></head><body><i>b</i></body>`,
+		"/site/data": body,
+	} {
+		w := httptest.NewRecorder()
+		pm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s 应返回 %s，实际 %s", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetHTTPSRedirect 设置 HTTP 到 HTTPS 的重定向
+// all 为 true 时重定向所有请求，否则只重定向开启了 force_https 的规则；port 为 HTTPS 端口，为空时使用 443
+func (pm *ProxyManager) SetHTTPSRedirect(all bool, port string) {
+	pm.redirectAll = all
+	pm.httpsPort = port
+}
+
+// RedirectHTTPS 将需要 HTTPS 的明文请求重定向到 HTTPS
+// 前置代理已终止 TLS 时，根据受信任代理传递的协议判断，避免循环重定向
+func (pm *ProxyManager) RedirectHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 没有需要重定向的规则时不解析客户端信息，也不查找路由
+		if !pm.redirectAll && !pm.routes.Load().forceHTTPS {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := pm.clientInfo(r)
+		if client.scheme == "https" || !pm.redirectAll && !pm.forceHTTPS(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := client.host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if pm.httpsPort != "" && pm.httpsPort != "443" {
+			host = net.JoinHostPort(host, pm.httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// forceHTTPS 判断请求匹配的规则是否要求 HTTPS
+func (pm *ProxyManager) forceHTTPS(r *http.Request) bool {
+	rt := pm.match(r)
+	return rt != nil && rt.rule.ForceHTTPS
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		all      bool
+		port     string
+		target   string
+		remote   string
+		headers  map[string]string
+		location string // 为空时不应重定向
+	}{
+		{name: "默认端口", all: true, target: "http://example.com/a?b=1", location: "https://example.com/a?b=1"},
+		{name: "去掉 HTTP 端口", all: true, target: "http://example.com:8080/a", location: "https://example.com/a"},
+		{name: "非 443 端口", all: true, port: "8443", target: "http://example.com:8080/a", location: "https://example.com:8443/a"},
+		{name: "显式 443 端口", all: true, port: "443", target: "http://example.com/a", location: "https://example.com/a"},
+		{name: "IPv6 地址", all: true, target: "http://[2001:db8::1]:8080/a", location: "https://[2001:db8::1]/a"},
+		{name: "IPv6 地址和非 443 端口", all: true, port: "8443", target: "http://[2001:db8::1]/a", location: "https://[2001:db8::1]:8443/a"},
+		{
+			name: "受信任代理已终止 TLS", all: true, target: "http://example.com/a", remote: "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+		},
+		{
+			name: "不受信任的 X-Forwarded-Proto", all: true, target: "http://example.com/a",
+			headers:  map[string]string{"X-Forwarded-Proto": "https"},
+			location: "https://example.com/a",
+		},
+		{
+			name: "使用受信任代理传递的域名", all: true, target: "http://internal/a", remote: "10.0.0.1:1234",
+			headers:  map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "example.com"},
+			location: "https://example.com/a",
+		},
+		{name: "只重定向 force_https 规则", target: "http://example.com/secure/x", location: "https://example.com/secure/x"},
+		{name: "其他规则不重定向", target: "http://example.com/plain/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := newTestProxyManager([]config.ProxyRule{
+				{ID: "secure", Name: "secure", Path: "/secure", Target: "http://127.0.0.1:1", ForceHTTPS: true},
+				{ID: "plain", Name: "plain", Path: "/plain", Target: "http://127.0.0.1:1"},
+			})
+			pm.SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+			pm.SetHTTPSRedirect(tt.all, tt.port)
+
+			handler := pm.RedirectHTTPS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.location == "" {
+				if w.Code != http.StatusNoContent {
+					t.Fatalf("不应重定向，实际 %d %s", w.Code, w.Header().Get("Location"))
+				}
+				return
+			}
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.location {
+				t.Fatalf("应重定向到 %s，实际 %d %s", tt.location, w.Code, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRedirectHTTPSWithoutForceRules(t *testing.T) {
+	pm := newTestProxyManager([]config.ProxyRule{
+		{ID: "plain", Name: "plain", Path: "/plain", Target: "http://127.0.0.1:1"},
+	})
+	if pm.routes.Load().forceHTTPS {
+		t.Fatal("没有 force_https 规则时路由表不应要求 HTTPS")
+	}
+
+	handler := pm.RedirectHTTPS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/plain/x", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("不应重定向，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"log"
+	"net/url"
+	"strings"
+)
+
+// hostMount 额外源站在规则前缀下的挂载目录，如 /nsmao/__host/cdn.nsmao.com/...
+const hostMount = "/__host"
+
+// extraOrigin 规则额外挂载的源站，如静态资源 CDN
+type extraOrigin struct {
+	upstream *upstream
+	links    *linkRewriter // 根相对地址映射到该源站的挂载路径之下
+}
+
+// newExtraOrigins 解析规则的额外源站，并将其加入路由的链接映射
+func newExtraOrigins(rt *route) map[string]*extraOrigin {
+	if len(rt.rule.ExtraOrigins) == 0 {
+		return nil
+	}
+
+	extras := make(map[string]*extraOrigin)
+	for _, raw := range rt.rule.ExtraOrigins {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Host == "" {
+			log.Printf("[Router] 规则 %s 的额外源站无效，已跳过: %s", rt.rule.Name, raw)
+			continue
+		}
+		host := strings.ToLower(u.Host)
+		if extras[host] != nil {
+			continue
+		}
+
+		up := &upstream{url: &url.URL{Scheme: u.Scheme, Host: u.Host}, weight: 1}
+		up.available.Store(true)
+		extras[host] = &extraOrigin{upstream: up}
+		rt.links.addOrigin(up.url, rt.hostPath(host))
+	}
+
+	// 所有源站加入映射后再复制，使每个源站的响应也能改写指向其他源站的链接
+	for host, eo := range extras {
+		eo.links = rt.links.withPrefix(rt.hostPath(host))
+	}
+	return extras
+}
+
+// hostPath 额外源站在代理中的挂载路径
+func (rt *route) hostPath(host string) string {
+	return rt.prefix + hostMount + "/" + host
+}
+
+// extraOrigin 判断请求路径是否指向额外源站，返回源站和转发到源站的路径
+func (rt *route) extraOrigin(path string) (*extraOrigin, string, bool) {
+	if len(rt.extras) == 0 {
+		return nil, "", false
+	}
+	rest, ok := strings.CutPrefix(path, rt.prefix+hostMount+"/")
+	if !ok {
+		return nil, "", false
+	}
+	host, upstreamPath, _ := strings.Cut(rest, "/")
+	eo, ok := rt.extras[strings.ToLower(host)]
+	if !ok {
+		return nil, "", false
+	}
+	return eo, "/" + upstreamPath, true
+}
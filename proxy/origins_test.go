@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newEchoUpstream 启动返回名称和收到的路径及查询参数的上游
+func newEchoUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.RequestURI())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExtraOriginRouting(t *testing.T) {
+	site := newEchoUpstream(t, "main")
+	cdn := newEchoUpstream(t, "cdn")
+	cdnHost := mustHost(t, cdn.URL)
+
+	pm := newTestProxyManager([]config.ProxyRule{{
+		ID: "site", Name: "site", Path: "/p", Target: site.URL + "/app", ExtraOrigins: []string{cdn.URL},
+	}})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/p/x?q=1", "main /app/x?q=1"},
+		// 挂载的源站收到原样的路径和查询参数，不带目标地址的路径
+		{"/p/__host/" + cdnHost + "/static/a.js?v=2&b=%2F", "cdn /static/a.js?v=2&b=%2F"},
+		{"/p/__host/" + cdnHost + "/", "cdn /"},
+		// 不在列表中的域名交给主上游，挂载路径不能用作开放代理
+		{"/p/__host/evil.example.com/x?q=1", "main /app/__host/evil.example.com/x?q=1"},
+		{"/p/__host/127.0.0.1:1/x", "main /app/__host/127.0.0.1:1/x"},
+	}
+	for _, tt := range tests {
+		code, body := serve(pm, "localhost", tt.path)
+		if code != http.StatusOK || body != tt.want {
+			t.Errorf("%s 应返回 %q，实际 %d %q", tt.path, tt.want, code, body)
+		}
+	}
+}
+
+// mustHost 解析地址中的 host[:port]
+func mustHost(t *testing.T, raw string) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
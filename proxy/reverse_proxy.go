@@ -1,73 +1,230 @@
 package proxy
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"go_proxy_every/config"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
-	"regexp"
+	"net/netip"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // ProxyManager 代理管理器
 type ProxyManager struct {
 	configManager *config.ConfigManager
+	routes        atomic.Pointer[routeTable]
+	rebuildMu     sync.Mutex
+
+	trustedProxies []netip.Prefix // 受信任的前置代理网段
+	websockets     sync.Map       // 规则ID -> *wsCounter
+
+	redirectAll bool   // 是否将所有 HTTP 请求重定向到 HTTPS
+	httpsPort   string // 重定向使用的 HTTPS 端口
 }
 
 // NewProxyManager 创建代理管理器
 func NewProxyManager(cm *config.ConfigManager) *ProxyManager {
-	return &ProxyManager{
+	pm := &ProxyManager{
 		configManager: cm,
 	}
+	pm.rebuildRoutes()
+	cm.OnChange(pm.rebuildRoutes)
+	return pm
+}
+
+// proxyContextKey 请求上下文中保存代理状态的键
+type proxyContextKey struct{}
+
+// proxyState 单次代理请求的状态，通过请求上下文传递给复用的 ReverseProxy
+type proxyState struct {
+	route     *route
+	upstream  *upstream
+	original  *http.Request
+	path      string        // 转发到上游的路径（不含目标地址自身的路径）
+	links     *linkRewriter // 重写本次响应使用的链接映射
+	extra     bool          // 是否转发到额外源站，额外源站不参与负载均衡和健康检查
+	requestID string        // 请求ID，用于头修改规则中的 {request_id}
+	client    clientInfo    // 客户端真实地址和协议
+}
+
+// stateFromContext 从请求上下文获取代理状态
+func stateFromContext(ctx context.Context) *proxyState {
+	state, _ := ctx.Value(proxyContextKey{}).(*proxyState)
+	return state
+}
+
+// rebuildRoutes 重建路由表并原子替换
+func (pm *ProxyManager) rebuildRoutes() {
+	pm.rebuildMu.Lock()
+	defer pm.rebuildMu.Unlock()
+
+	table := buildRouteTable(pm.configManager.GetEnabledRules(), pm.routes.Load(), pm.newReverseProxy)
+	pm.routes.Store(table)
+
+	// 清理已删除或停用的规则的 WebSocket 连接计数
+	pm.websockets.Range(func(key, _ any) bool {
+		if _, ok := table.byID[key.(string)]; !ok {
+			pm.websockets.Delete(key)
+		}
+		return true
+	})
+}
+
+// Health 获取所有启用规则的上游健康状态
+func (pm *ProxyManager) Health() []RuleHealth {
+	table := pm.routes.Load()
+
+	result := make([]RuleHealth, 0, len(table.routes))
+	for _, rt := range table.routes {
+		rule := rt.rule
+		rh := RuleHealth{
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			HealthCheck: rule.HealthCheck.Enabled,
+			Passive:     rule.HealthCheck.Passive,
+			Upstreams:   make([]UpstreamStatus, 0, len(rt.balancer.upstreams)),
+		}
+		for _, u := range rt.balancer.upstreams {
+			rh.Upstreams = append(rh.Upstreams, u.status())
+		}
+		result = append(result, rh)
+	}
+	return result
 }
 
 // ServeHTTP 处理代理请求
 func (pm *ProxyManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-
-	// 查找匹配的规则
-	rules := pm.configManager.GetEnabledRules()
-	for _, rule := range rules {
-		prefix := rule.Path
-		if !strings.HasPrefix(prefix, "/") {
-			prefix = "/" + prefix
+	rt := pm.match(r)
+	if rt == nil {
+		// 没有匹配的规则
+		if IsGRPCRequest(r) {
+			writeGRPCError(w, grpcUnimplemented, "No proxy rule matched")
+			return
 		}
+		http.Error(w, "No proxy rule matched", http.StatusNotFound)
+		return
+	}
+
+	pm.handleProxy(w, r, rt)
+}
 
-		if strings.HasPrefix(path, prefix+"/") || path == prefix {
-			pm.handleProxy(w, r, rule, prefix)
+// Hosts 列出启用的规则中配置的精确域名（不含通配符域名）
+func (pm *ProxyManager) Hosts() []string {
+	table := pm.routes.Load()
+	hosts := make([]string, 0, len(table.exact))
+	for host := range table.exact {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// HasHost 判断是否有启用的规则配置了该精确域名
+func (pm *ProxyManager) HasHost(host string) bool {
+	_, ok := pm.routes.Load().exact[host]
+	return ok
+}
+
+// Handler 返回服务入口，每个请求只查找一次路由
+// 配置了域名的规则拥有该域名下的所有路径；其余请求中，admin 注册的管理面板、API 等路径优先于未指定域名的规则
+// adminHost 不为空时，管理路径只在该域名下提供。客户端重写脚本在所有域名下提供
+func (pm *ProxyManager) Handler(admin *http.ServeMux, adminHost string) http.Handler {
+	adminHost = strings.ToLower(strings.TrimSpace(adminHost))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ShimPath {
+			ServeShim(w, r)
 			return
 		}
-	}
 
-	// 没有匹配的规则
-	http.Error(w, "No proxy rule matched", http.StatusNotFound)
+		rt := pm.match(r)
+		if rt != nil && rt.rule.Host != "" {
+			pm.handleProxy(w, r, rt)
+			return
+		}
+
+		_, pattern := admin.Handler(r)
+		switch {
+		case pattern != "/" && (adminHost == "" || requestHost(r) == adminHost):
+			admin.ServeHTTP(w, r)
+		case rt != nil:
+			pm.handleProxy(w, r, rt)
+		case IsGRPCRequest(r):
+			// 未匹配的 gRPC 请求以 grpc-status 而不是 HTML 页面返回错误
+			writeGRPCError(w, grpcUnimplemented, "No proxy rule matched")
+		case pattern == "/":
+			admin.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// match 按优先级、域名和最长前缀查找路由
+func (pm *ProxyManager) match(r *http.Request) *route {
+	return pm.routes.Load().match(r)
 }
 
 // handleProxy 处理具体的代理请求
-func (pm *ProxyManager) handleProxy(w http.ResponseWriter, r *http.Request, rule config.ProxyRule, prefix string) {
-	targetURL, err := url.Parse(rule.Target)
-	if err != nil {
-		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+func (pm *ProxyManager) handleProxy(w http.ResponseWriter, r *http.Request, rt *route) {
+	if isWebSocketRequest(r) {
+		pm.serveWebSocket(w, r, rt)
 		return
 	}
+	pm.serveRoute(w, r, rt)
+}
 
-	proxy := &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			// 移除前缀路径
-			originalPath := req.URL.Path
-			newPath := strings.TrimPrefix(originalPath, prefix)
-			if newPath == "" {
-				newPath = "/"
+// serveRoute 选择上游并通过路由的 ReverseProxy 转发请求
+func (pm *ProxyManager) serveRoute(w http.ResponseWriter, r *http.Request, rt *route) {
+	state := &proxyState{route: rt, original: r, links: rt.links, requestID: requestID(r), client: pm.clientInfo(r)}
+	if eo, path, ok := rt.extraOrigin(r.URL.Path); ok {
+		// 挂载在 /__host/<域名>/ 之下的额外源站
+		state.upstream, state.path, state.links, state.extra = eo.upstream, path, eo.links, true
+	} else {
+		state.upstream = rt.balancer.pick(r, state.client.ip)
+		if state.upstream == nil {
+			if IsGRPCRequest(r) {
+				writeGRPCError(w, grpcUnavailable, "No healthy upstream available")
+				return
 			}
+			http.Error(w, "No healthy upstream available", http.StatusServiceUnavailable)
+			return
+		}
+		// 移除前缀或按正则模板重写路径
+		state.path = rt.upstreamPath(r.URL.Path)
+	}
+
+	up := state.upstream
+	up.active.Add(1)
+	defer up.active.Add(-1)
+
+	rt.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyContextKey{}, state)))
+}
+
+// newReverseProxy 为路由创建可复用的 ReverseProxy，每次请求的上游通过上下文传入
+func (pm *ProxyManager) newReverseProxy(rt *route) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: rt.transport,
+		// 事件流和长度未知的响应总是立即刷新，其余响应按规则配置的间隔刷新
+		FlushInterval: rt.flushInterval(),
+		// 使用 Rewrite 而不是 Director，X-Forwarded-For 完全由代理自己设置，规则可以覆盖或删除
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			req := pr.Out
+			state := stateFromContext(req.Context())
+			r := state.original
+			targetURL := state.upstream.url
+
+			originalPath := req.URL.Path
 
 			req.URL.Scheme = targetURL.Scheme
 			req.URL.Host = targetURL.Host
-			req.URL.Path = singleJoiningSlash(targetURL.Path, newPath)
+			req.URL.Path = singleJoiningSlash(targetURL.Path, state.path)
 
 			// 设置Host头
 			req.Host = targetURL.Host
@@ -77,94 +234,163 @@ func (pm *ProxyManager) handleProxy(w http.ResponseWriter, r *http.Request, rule
 				req.URL.RawQuery = r.URL.RawQuery
 			}
 
-			// 设置必要的头
-			req.Header.Set("X-Forwarded-Host", r.Host)
-			req.Header.Set("X-Forwarded-Proto", "http")
-			req.Header.Set("X-Real-IP", getClientIP(r))
+			filterRequestCookies(req, rt)
 
-			// 删除可能导致问题的头
-			req.Header.Del("Accept-Encoding") // 禁用压缩以便修改响应
+			// 只向上游声明能够解码的压缩方式，以便修改响应
+			if ae := filterAcceptEncoding(r.Header.Get("Accept-Encoding")); ae != "" {
+				req.Header.Set("Accept-Encoding", ae)
+			} else {
+				req.Header.Del("Accept-Encoding")
+			}
+
+			// 先设置默认的转发头，再执行规则中的请求头修改
+			state.client.forwardHeaders(req, r)
+			vars := state.headerVars()
+			applyRequestHeaderRules(req, defaultRequestHeaders, vars)
+			applyRequestHeaderRules(req, rt.rule.RequestHeaders, vars)
 
-			log.Printf("[Proxy] %s -> %s%s", originalPath, targetURL.Host, req.URL.Path)
+			log.Printf("[Proxy] %s %s -> %s%s", state.client.ip, originalPath, targetURL.Host, req.URL.Path)
 		},
 		ModifyResponse: func(resp *http.Response) error {
+			state := stateFromContext(resp.Request.Context())
+			if !state.extra {
+				rt.balancer.health.observe(state.upstream, resp.StatusCode, nil)
+			}
+
+			// 协议升级响应（WebSocket）的连接由 ReverseProxy 直接转发，不做任何修改
+			if resp.StatusCode == http.StatusSwitchingProtocols {
+				return nil
+			}
+
 			// 修改响应中的链接
-			return pm.modifyResponse(resp, rule, prefix)
+			return pm.modifyResponse(resp, rt, state)
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("[Proxy Error] %v", err)
+			state := stateFromContext(r.Context())
+			log.Printf("[Proxy Error] %s %s: %v", state.client.ip, state.original.URL.Path, err)
+			if !state.extra && !errors.Is(err, context.Canceled) {
+				rt.balancer.health.observe(state.upstream, 0, err)
+			}
+			if IsGRPCRequest(r) {
+				writeGRPCError(w, grpcStatusFromError(err), fmt.Sprintf("Proxy error: %v", err))
+				return
+			}
 			http.Error(w, fmt.Sprintf("Proxy error: %v", err), http.StatusBadGateway)
 		},
 	}
-
-	proxy.ServeHTTP(w, r)
 }
 
 // modifyResponse 修改响应内容
-func (pm *ProxyManager) modifyResponse(resp *http.Response, rule config.ProxyRule, prefix string) error {
-	contentType := resp.Header.Get("Content-Type")
+func (pm *ProxyManager) modifyResponse(resp *http.Response, rt *route, state *proxyState) error {
+	r, links := state.original, state.links
+
+	// 重写重定向等响应头中的上游地址和 Cookie，按规则处理安全响应头
+	rewriteResponseHeaders(resp.Header, links)
+	rewriteSetCookies(resp.Header, rt, links)
+	applySecurityHeaders(resp.Header, rt.rule.SecurityHeaders, links)
+
+	// 执行规则中的响应头修改，放在链接和 Cookie 改写之后，结果不会再被改写
+	applyHeaderRules(resp.Header, rt.rule.ResponseHeaders, state.headerVars())
 
-	// 只处理HTML内容
-	if !strings.Contains(contentType, "text/html") {
+	// 没有响应体时无需处理
+	if r.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
 		return nil
 	}
 
-	// 读取响应体
-	var reader io.ReadCloser
-	var err error
+	// 分段响应只是完整内容的一部分，改写后会与 Content-Range 描述的偏移不一致，原样透传
+	if resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Content-Range") != "" {
+		return nil
+	}
+
+	// 流式响应边收边发，任何内容重写都需要缓冲或改变分块边界，一律跳过
+	contentType := resp.Header.Get("Content-Type")
+	if rt.isStreaming(contentType) {
+		return nil
+	}
 
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			return err
+	// 根据内容类型和规则开关选择重写方式
+	opts := rt.rule.Rewriting
+	var rewrite func(io.ReadCloser) io.ReadCloser
+	switch {
+	case strings.Contains(contentType, "text/html") && (!opts.DisableHTML || opts.ClientShim || rt.hasInjection()):
+		// 流式重写HTML中的链接，并按需注入客户端重写脚本和自定义片段
+		rewrite = func(body io.ReadCloser) io.ReadCloser {
+			return newHTMLRewriter(body, links, opts, rt.rule.Inject, rt.cookieNamespace())
+		}
+	case isCSSType(contentType) && opts.CSS:
+		rewrite = func(body io.ReadCloser) io.ReadCloser {
+			return newBufferedRewriter(body, maxTextRewriteSize, func(data []byte) []byte {
+				return []byte(rewriteCSS(string(data), links, opts.RootRelative))
+			})
+		}
+	case isJSType(contentType) && opts.JS:
+		rewrite = func(body io.ReadCloser) io.ReadCloser {
+			return newBufferedRewriter(body, maxTextRewriteSize, func(data []byte) []byte {
+				return []byte(rewriteJS(string(data), links, opts.RootRelative))
+			})
 		}
-		defer reader.Close()
-	default:
-		reader = resp.Body
 	}
 
-	body, err := io.ReadAll(reader)
+	// 任意文本类型都可以按规则替换内容
+	subs := rt.substitutionsFor(contentType)
+	if rewrite == nil && len(subs) == 0 {
+		return nil
+	}
+
+	// 解压响应体，无法识别的压缩方式原样透传
+	body, ok, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if !ok {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
 
-	// 重写HTML中的链接
-	bodyStr := string(body)
-	bodyStr = pm.rewriteHTML(bodyStr, rule, prefix)
+	// 先对上游原始内容执行替换，再重写链接，处理后长度未知，使用分块传输
+	rewritten := body
+	if len(subs) > 0 {
+		rewritten = newBufferedRewriter(rewritten, rt.maxBodySize(), func(data []byte) []byte {
+			return applySubstitutions(data, subs)
+		})
+	}
+	if rewrite != nil {
+		rewritten = rewrite(rewritten)
+	}
 
-	// 更新响应
-	resp.Body = io.NopCloser(bytes.NewReader([]byte(bodyStr)))
-	resp.ContentLength = int64(len(bodyStr))
-	resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyStr)))
-	resp.Header.Del("Content-Encoding") // 移除压缩标记
+	// 按客户端支持的编码重新压缩
+	resp.Header.Del("Content-Encoding")
+	if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+		rewritten = encodeBody(rewritten, encoding)
+		resp.Header.Set("Content-Encoding", encoding)
+	}
+	addVary(resp.Header, "Accept-Encoding")
 
-	return nil
-}
+	// 改写后的内容与上游字节不同，不能再按上游的强 ETag 续传或按字节范围请求
+	resp.Header.Del("Accept-Ranges")
+	weakenETag(resp.Header)
 
-// rewriteHTML 重写HTML中的链接
-func (pm *ProxyManager) rewriteHTML(html string, rule config.ProxyRule, prefix string) string {
-	targetURL, err := url.Parse(rule.Target)
-	if err != nil {
-		return html
-	}
+	resp.Body = rewritten
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
 
-	// 替换绝对URL
-	// 例如：https://www.nsmao.com/xxx -> /nsmao/xxx
-	absolutePattern := regexp.MustCompile(fmt.Sprintf(`(href|src|action)=["']%s(/[^"']*)?["']`, regexp.QuoteMeta(rule.Target)))
-	html = absolutePattern.ReplaceAllStringFunc(html, func(match string) string {
-		return strings.Replace(match, rule.Target, prefix, 1)
-	})
+	return nil
+}
 
-	// 替换协议相对URL
-	// 例如：//www.nsmao.com/xxx -> /nsmao/xxx
-	protoRelativePattern := regexp.MustCompile(fmt.Sprintf(`(href|src|action)=["']//%s(/[^"']*)?["']`, regexp.QuoteMeta(targetURL.Host)))
-	html = protoRelativePattern.ReplaceAllStringFunc(html, func(match string) string {
-		return strings.Replace(match, "//"+targetURL.Host, prefix, 1)
-	})
+// readCloser 组合读取器和需要一并关闭的资源
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
 
-	return html
+// Close 依次关闭所有资源
+func (rc *readCloser) Close() error {
+	var firstErr error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // singleJoiningSlash 连接路径
@@ -179,24 +405,3 @@ func singleJoiningSlash(a, b string) string {
 	}
 	return a + b
 }
-
-// getClientIP 获取客户端IP
-func getClientIP(r *http.Request) string {
-	// 尝试从X-Forwarded-For获取
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	// 尝试从X-Real-IP获取
-	xri := r.Header.Get("X-Real-IP")
-	if xri != "" {
-		return xri
-	}
-
-	// 使用RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
-}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// linkRewriter 将指向上游的链接映射为代理路径
+type linkRewriter struct {
+	prefix  string
+	root    string // 主上游地址自身的路径，根相对地址只有落在其下时才映射到前缀之下
+	origins []linkOrigin
+}
+
+// linkOrigin 需要映射的上游地址
+type linkOrigin struct {
+	host string // 小写的 host[:port]
+	path string // 上游地址自身的路径，不含结尾斜杠
+	base string // 映射到的代理路径，如 /nsmao 或 /nsmao/__host/cdn.nsmao.com
+}
+
+// newLinkRewriter 创建链接重写器，上游地址映射到 prefix 之下
+func newLinkRewriter(prefix string, origins []*url.URL) *linkRewriter {
+	lr := &linkRewriter{prefix: prefix}
+	for _, o := range origins {
+		lr.addOrigin(o, prefix)
+	}
+	if len(lr.origins) > 0 {
+		lr.root = lr.origins[0].path
+	}
+	return lr
+}
+
+// addOrigin 追加需要映射的上游地址，映射到 base 之下
+func (lr *linkRewriter) addOrigin(origin *url.URL, base string) {
+	lr.origins = append(lr.origins, linkOrigin{
+		host: strings.ToLower(origin.Host),
+		path: strings.TrimSuffix(origin.Path, "/"),
+		base: base,
+	})
+}
+
+// withPrefix 复制一个根相对地址使用新前缀的重写器，上游地址的映射保持不变
+// 额外源站只包含协议和域名，根相对地址直接映射到新前缀之下
+func (lr *linkRewriter) withPrefix(prefix string) *linkRewriter {
+	return &linkRewriter{prefix: prefix, origins: slices.Clone(lr.origins)}
+}
+
+// rewrite 重写单个链接，返回新链接和是否发生了改写
+// 支持绝对地址（https://www.nsmao.com/xxx）和协议相对地址（//www.nsmao.com/xxx）
+func (lr *linkRewriter) rewrite(raw string) (string, bool) {
+	value := strings.TrimSpace(raw)
+
+	var rest string
+	switch {
+	case hasPrefixFold(value, "http://"):
+		rest = value[len("http://"):]
+	case hasPrefixFold(value, "https://"):
+		rest = value[len("https://"):]
+	case strings.HasPrefix(value, "//"):
+		rest = value[len("//"):]
+	default:
+		return raw, false
+	}
+
+	for _, o := range lr.origins {
+		if !hasPrefixFold(rest, o.host) {
+			continue
+		}
+		path := rest[len(o.host):]
+		if !isPathBoundary(path) || !strings.HasPrefix(path, o.path) {
+			continue
+		}
+		path = path[len(o.path):]
+		if !isPathBoundary(path) {
+			continue
+		}
+		return joinPath(o.base, path), true
+	}
+
+	return raw, false
+}
+
+// rewriteWithRoot 在 rewrite 的基础上同时处理根相对地址（/login -> /nsmao/login）
+// 上游地址带路径时先去掉该路径（/app/login -> /nsmao/login），不在其下的地址保持不变
+func (lr *linkRewriter) rewriteWithRoot(raw string) (string, bool) {
+	if rewritten, ok := lr.rewrite(raw); ok {
+		return rewritten, true
+	}
+
+	value := strings.TrimSpace(raw)
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+		return raw, false
+	}
+	path, ok := lr.stripRoot(value)
+	if !ok || (lr.prefix == "" && path == value) {
+		return raw, false
+	}
+	return lr.join(path), true
+}
+
+// stripRoot 去掉根相对地址开头的上游路径，地址不在上游路径之下时返回 false
+func (lr *linkRewriter) stripRoot(path string) (string, bool) {
+	if !strings.HasPrefix(path, lr.root) || !isPathBoundary(path[len(lr.root):]) {
+		return path, false
+	}
+	return path[len(lr.root):], true
+}
+
+// join 将上游路径拼接到代理前缀之下
+func (lr *linkRewriter) join(path string) string {
+	return joinPath(lr.prefix, path)
+}
+
+// joinPath 将上游路径拼接到代理路径 base 之下
+func joinPath(base, path string) string {
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+	if base == "" {
+		return path
+	}
+	if path == "/" {
+		return base + "/"
+	}
+	return base + path
+}
+
+// rewriteSrcset 重写 srcset 中的每个候选地址，root 为 true 时同时处理根相对地址
+func (lr *linkRewriter) rewriteSrcset(value string, root bool) (string, bool) {
+	rewrite := lr.rewrite
+	if root {
+		rewrite = lr.rewriteWithRoot
+	}
+
+	candidates := strings.Split(value, ",")
+	changed := false
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if rewritten, ok := rewrite(fields[0]); ok {
+			fields[0] = rewritten
+			changed = true
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	if !changed {
+		return value, false
+	}
+	return strings.Join(candidates, ", "), true
+}
+
+// isPathBoundary 判断剩余部分是否从路径边界开始
+func isPathBoundary(rest string) bool {
+	return rest == "" || rest[0] == '/' || rest[0] == '?' || rest[0] == '#'
+}
+
+// hasPrefixFold 忽略大小写的前缀判断
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// newTestLinks 创建映射 target 到 prefix 之下的链接重写器
+func newTestLinks(t *testing.T, prefix, target string) *linkRewriter {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newLinkRewriter(prefix, []*url.URL{u})
+}
+
+func TestRewriteWithRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		target string
+		in     string
+		want   string
+	}{
+		{"根相对地址", "/p", "http://up", "/dash", "/p/dash"},
+		{"上游绝对地址", "/p", "http://up", "http://up/dash?a=1", "/p/dash?a=1"},
+		{"协议相对地址", "/p", "http://up", "//up/dash", "/p/dash"},
+		{"其他站点", "/p", "http://up", "https://other/dash", "https://other/dash"},
+		{"相对地址", "/p", "http://up", "dash", "dash"},
+		{"上游路径下的根相对地址", "/p", "http://up/app", "/app/dash", "/p/dash"},
+		{"上游路径本身", "/p", "http://up/app", "/app", "/p/"},
+		{"上游路径带查询", "/p", "http://up/app", "/app?x=1", "/p/?x=1"},
+		{"上游路径之外", "/p", "http://up/app", "/other", "/other"},
+		{"上游路径前缀相同", "/p", "http://up/app", "/apple", "/apple"},
+		{"上游路径下的绝对地址", "/p", "http://up/app", "http://up/app/dash", "/p/dash"},
+		{"上游路径之外的绝对地址", "/p", "http://up/app", "http://up/other", "http://up/other"},
+		{"保留前缀时去掉上游路径", "", "http://up/app", "/app/p/dash", "/p/dash"},
+		{"保留前缀且无上游路径", "", "http://up", "/dash", "/dash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := newTestLinks(t, tt.prefix, tt.target)
+			if got, _ := links.rewriteWithRoot(tt.in); got != tt.want {
+				t.Fatalf("%q 应重写为 %q，实际 %q", tt.in, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRewriteWithRootExtraOrigin(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	cdn, _ := url.Parse("https://cdn.example.com")
+	links.addOrigin(cdn, "/p/__host/cdn.example.com")
+
+	// 额外源站页面中的根相对地址直接映射到该源站的代理路径之下
+	eo := links.withPrefix("/p/__host/cdn.example.com")
+	if got, _ := eo.rewriteWithRoot("/app/x.js"); got != "/p/__host/cdn.example.com/app/x.js" {
+		t.Fatalf("额外源站的根相对地址映射错误: %q", got)
+	}
+	if got, _ := links.rewriteWithRoot("https://cdn.example.com/x.js"); got != "/p/__host/cdn.example.com/x.js" {
+		t.Fatalf("额外源站的绝对地址映射错误: %q", got)
+	}
+}
+
+func TestRewriteResponseHeadersTargetPath(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	h := http.Header{}
+	h.Set("Location", "/app/dash")
+	h.Set("Content-Location", "http://up/app/item/1")
+	h.Set("Refresh", "5; url=/app/login")
+	h.Add("Link", "</app/style.css>; rel=preload, </static/x.js>; rel=preload")
+
+	rewriteResponseHeaders(h, links)
+
+	want := map[string]string{
+		"Location":         "/p/dash",
+		"Content-Location": "/p/item/1",
+		"Refresh":          "5; url=/p/login",
+		"Link":             "</p/style.css>; rel=preload, </static/x.js>; rel=preload",
+	}
+	for name, v := range want {
+		if got := h.Get(name); got != v {
+			t.Errorf("%s 应为 %q，实际 %q", name, v, got)
+		}
+	}
+}
+
+func TestRewriteSrcset(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	tests := []struct {
+		name    string
+		root    bool
+		in      string
+		want    string
+		changed bool
+	}{
+		{"绝对地址", false, "http://up/app/a.png 1x, http://up/app/b.png 2x", "/p/a.png 1x, /p/b.png 2x", true},
+		{"未开启根相对地址", false, "/app/a.png 1x", "/app/a.png 1x", false},
+		{"根相对地址", true, "/app/a.png 480w,/app/b.png 800w", "/p/a.png 480w, /p/b.png 800w", true},
+		{"部分改写", true, "/app/a.png 1x, https://cdn/b.png 2x", "/p/a.png 1x, https://cdn/b.png 2x", true},
+		{"没有描述符", true, "/app/a.png", "/p/a.png", true},
+		{"多余空白", true, "  /app/a.png   1x", "/p/a.png 1x", true},
+		{"上游路径之外", true, "/other/a.png 1x", "/other/a.png 1x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := links.rewriteSrcset(tt.in, tt.root)
+			if got != tt.want || changed != tt.changed {
+				t.Fatalf("%q 应重写为 %q（%v），实际 %q（%v）", tt.in, tt.want, tt.changed, got, changed)
+			}
+		})
+	}
+}
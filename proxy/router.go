@@ -0,0 +1,353 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// route 编译后的路由
+type route struct {
+	rule   config.ProxyRule
+	prefix string // 规范化后的路径前缀，根路径为空字符串
+	order  int    // 规则在配置中的顺序
+	re     *regexp.Regexp
+
+	// 以下运行时状态在规则未修改时跨路由表重建复用
+	balancer  *balancer
+	transport upstreamTransport
+	proxy     *httputil.ReverseProxy
+	links     *linkRewriter
+	extras    map[string]*extraOrigin // 额外挂载的源站，按小写 host[:port] 索引
+	subs      []substitution          // 响应内容替换规则
+}
+
+// matchPath 判断请求路径是否落在路由前缀之下（按路径段边界匹配），并满足路径正则
+func (rt *route) matchPath(path string) bool {
+	if rt.prefix != "" {
+		if !strings.HasPrefix(path, rt.prefix) {
+			return false
+		}
+		if len(path) != len(rt.prefix) && path[len(rt.prefix)] != '/' {
+			return false
+		}
+	}
+	if rt.re == nil {
+		return true
+	}
+	// 额外源站的挂载路径不受路径正则限制
+	if _, _, ok := rt.extraOrigin(path); ok {
+		return true
+	}
+	return rt.re.MatchString(path)
+}
+
+// upstreamPath 计算转发到上游的路径（不含目标地址自身的路径）
+func (rt *route) upstreamPath(path string) string {
+	var newPath string
+	switch {
+	case rt.re != nil && rt.rule.Rewrite != "":
+		// 使用捕获组展开路径模板
+		match := rt.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			newPath = path
+			break
+		}
+		newPath = string(rt.re.ExpandString(nil, rt.rule.Rewrite, path, match))
+	case rt.rule.KeepPrefix:
+		newPath = path
+	default:
+		newPath = strings.TrimPrefix(path, rt.prefix)
+	}
+
+	if !strings.HasPrefix(newPath, "/") {
+		newPath = "/" + newPath
+	}
+	return newPath
+}
+
+// linkPrefix 重写响应中上游链接时使用的前缀
+// 保留前缀时上游路径与访问路径一致，无需再添加前缀
+func (rt *route) linkPrefix() string {
+	if rt.rule.KeepPrefix {
+		return ""
+	}
+	return rt.prefix
+}
+
+// hasInjection 是否配置了注入页面的 HTML 片段
+func (rt *route) hasInjection() bool {
+	return rt.rule.Inject.Head != "" || rt.rule.Inject.Body != ""
+}
+
+// radixNode 基数树节点，按路径前缀组织路由
+type radixNode struct {
+	path     string
+	children []*radixNode
+	routes   []*route
+}
+
+// insert 插入路由
+func (n *radixNode) insert(key string, rt *route) {
+	if key == "" {
+		n.routes = append(n.routes, rt)
+		return
+	}
+
+	for i, child := range n.children {
+		common := commonPrefixLen(key, child.path)
+		if common == 0 {
+			continue
+		}
+
+		// 公共前缀短于子节点路径时拆分子节点
+		if common < len(child.path) {
+			split := &radixNode{path: child.path[:common], children: []*radixNode{child}}
+			child.path = child.path[common:]
+			n.children[i] = split
+			child = split
+		}
+		child.insert(key[common:], rt)
+		return
+	}
+
+	n.children = append(n.children, &radixNode{path: key, routes: []*route{rt}})
+}
+
+// walk 沿请求路径向下遍历，依次访问所有前缀匹配的路由
+func (n *radixNode) walk(path string, fn func(rt *route)) {
+	rest := path
+	for n != nil {
+		for _, rt := range n.routes {
+			fn(rt)
+		}
+
+		var next *radixNode
+		for _, child := range n.children {
+			if strings.HasPrefix(rest, child.path) {
+				next = child
+				rest = rest[len(child.path):]
+				break
+			}
+		}
+		n = next
+	}
+}
+
+// commonPrefixLen 计算公共前缀长度
+func commonPrefixLen(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// wildcardTree 通配符域名对应的路由树
+type wildcardTree struct {
+	suffix string // 如 .example.com
+	tree   *radixNode
+}
+
+// routeTable 路由表，规则变更时整体重建，构建完成后只读
+type routeTable struct {
+	exact    map[string]*radixNode
+	wildcard []wildcardTree // 按后缀长度降序
+	any      *radixNode
+	routes   []*route          // 所有路由，按规则顺序
+	byID     map[string]*route // 规则ID -> 路由，用于重建时复用运行时状态
+
+	forceHTTPS bool // 是否有规则要求 HTTPS
+}
+
+// candidate 路由匹配候选
+type candidate struct {
+	rt        *route
+	hostScore int // 2 精确域名，1 通配符域名，0 未指定域名
+	hostLen   int
+}
+
+// better 判断候选 a 是否优于 b：优先级 > 域名精确度 > 最长前缀 > 配置顺序
+func (a candidate) better(b candidate) bool {
+	if a.rt.rule.Priority != b.rt.rule.Priority {
+		return a.rt.rule.Priority > b.rt.rule.Priority
+	}
+	if a.hostScore != b.hostScore {
+		return a.hostScore > b.hostScore
+	}
+	if a.hostLen != b.hostLen {
+		return a.hostLen > b.hostLen
+	}
+	if len(a.rt.prefix) != len(b.rt.prefix) {
+		return len(a.rt.prefix) > len(b.rt.prefix)
+	}
+	return a.rt.order < b.rt.order
+}
+
+// buildRouteTable 根据启用的规则构建路由表，newProxy 为新建的路由创建 ReverseProxy
+// 未修改的规则沿用旧路由表中的运行时状态（负载均衡计数等）
+func buildRouteTable(rules []config.ProxyRule, prev *routeTable, newProxy func(rt *route) *httputil.ReverseProxy) *routeTable {
+	table := &routeTable{
+		exact: make(map[string]*radixNode),
+		any:   &radixNode{},
+		byID:  make(map[string]*route),
+	}
+	wildcards := make(map[string]*radixNode)
+	reused := make(map[*balancer]bool)
+
+	for i, rule := range rules {
+		rt := &route{
+			rule:   rule,
+			prefix: normalizePrefix(rule.Path),
+			order:  i,
+		}
+		if rule.MatchRegex != "" {
+			re, err := regexp.Compile(rule.MatchRegex)
+			if err != nil {
+				log.Printf("[Router] 规则 %s 的路径正则无效，已跳过: %v", rule.Name, err)
+				continue
+			}
+			rt.re = re
+		}
+
+		// 旧路由只能被复用一次，避免 ID 重复的规则共用同一份运行时状态
+		if old := prev.lookup(rule); old != nil && !reused[old.balancer] {
+			rt.balancer = old.balancer
+			rt.transport = old.transport
+			rt.proxy = old.proxy
+			reused[old.balancer] = true
+		} else {
+			tlsConfig, err := newUpstreamTLSConfig(rule.TLS)
+			if err != nil {
+				log.Printf("[Router] 规则 %s 的上游 TLS 配置无效，已跳过: %v", rule.Name, err)
+				continue
+			}
+			if rule.TLS.InsecureSkipVerify {
+				log.Printf("[Router] 警告: 规则 %s 已关闭上游证书校验，连接可能被中间人劫持", rule.Name)
+			}
+			rt.transport = newTransport(rule.Transport, tlsConfig)
+			if tlsConfig != nil && len(rule.ExtraOrigins) > 0 {
+				rt.transport = &originTransport{upstream: rt.transport, extra: newTransport(rule.Transport, nil)}
+			}
+			rt.balancer = newBalancer(rule, rt.transport)
+		}
+		rt.links = newLinkRewriter(rt.linkPrefix(), rt.balancer.origins())
+		rt.extras = newExtraOrigins(rt)
+		rt.subs = compileSubstitutions(rule)
+		if rt.proxy == nil {
+			rt.proxy = newProxy(rt)
+		}
+		table.routes = append(table.routes, rt)
+		table.forceHTTPS = table.forceHTTPS || rule.ForceHTTPS
+		if _, ok := table.byID[rule.ID]; !ok && rule.ID != "" {
+			table.byID[rule.ID] = rt
+		}
+
+		host := strings.ToLower(strings.TrimSpace(rule.Host))
+		switch {
+		case host == "":
+			table.any.insert(rt.prefix, rt)
+		case strings.HasPrefix(host, "*."):
+			suffix := host[1:]
+			if wildcards[suffix] == nil {
+				wildcards[suffix] = &radixNode{}
+			}
+			wildcards[suffix].insert(rt.prefix, rt)
+		default:
+			if table.exact[host] == nil {
+				table.exact[host] = &radixNode{}
+			}
+			table.exact[host].insert(rt.prefix, rt)
+		}
+	}
+
+	for suffix, tree := range wildcards {
+		table.wildcard = append(table.wildcard, wildcardTree{suffix: suffix, tree: tree})
+	}
+	sort.Slice(table.wildcard, func(i, j int) bool {
+		return len(table.wildcard[i].suffix) > len(table.wildcard[j].suffix)
+	})
+
+	// 释放已删除、停用或被修改的规则占用的资源
+	if prev != nil {
+		for _, old := range prev.routes {
+			if !reused[old.balancer] {
+				old.balancer.close()
+				old.transport.CloseIdleConnections()
+			}
+		}
+	}
+
+	return table
+}
+
+// lookup 查找未修改过的同一规则的旧路由
+func (t *routeTable) lookup(rule config.ProxyRule) *route {
+	if t == nil {
+		return nil
+	}
+	old, ok := t.byID[rule.ID]
+	if !ok || !old.rule.UpdatedAt.Equal(rule.UpdatedAt) {
+		return nil
+	}
+	return old
+}
+
+// match 查找请求对应的最佳路由
+func (t *routeTable) match(r *http.Request) *route {
+	host := requestHost(r)
+	path := r.URL.Path
+
+	var best candidate
+	visit := func(tree *radixNode, hostScore, hostLen int) {
+		tree.walk(path, func(rt *route) {
+			if !rt.matchPath(path) {
+				return
+			}
+			c := candidate{rt: rt, hostScore: hostScore, hostLen: hostLen}
+			if best.rt == nil || c.better(best) {
+				best = c
+			}
+		})
+	}
+
+	if tree, ok := t.exact[host]; ok {
+		visit(tree, 2, len(host))
+	}
+	for _, w := range t.wildcard {
+		// *.example.com 匹配任意子域名，但不匹配 example.com 本身
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			visit(w.tree, 1, len(w.suffix))
+		}
+	}
+	visit(t.any, 0, 0)
+
+	return best.rt
+}
+
+// normalizePrefix 规范化规则路径前缀，根路径返回空字符串
+func normalizePrefix(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// requestHost 获取请求的域名（去除端口，小写）
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
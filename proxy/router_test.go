@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+)
+
+// newTestUpstream 启动返回固定内容的上游服务
+func newTestUpstream(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestProxyManager 创建按给定规则转发的代理管理器
+func newTestProxyManager(rules []config.ProxyRule) *ProxyManager {
+	pm := &ProxyManager{}
+	pm.routes.Store(buildRouteTable(rules, nil, pm.newReverseProxy))
+	return pm
+}
+
+// serve 通过代理管理器发送请求，返回状态码和响应内容
+func serve(pm *ProxyManager, host, path string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestRouterMatch(t *testing.T) {
+	rules := []config.ProxyRule{
+		{ID: "api", Path: "/api", Target: "http://api.internal"},
+		{ID: "api-v2", Path: "/api/v2", Target: "http://v2.internal"},
+		{ID: "host", Host: "example.com", Path: "/api", Target: "http://host.internal"},
+		{ID: "wildcard", Host: "*.example.com", Target: "http://wildcard.internal"},
+		{ID: "priority", Path: "/api/v2/admin", Priority: 10, Host: "*.example.com", Target: "http://admin.internal"},
+	}
+	table := buildRouteTable(rules, nil, func(rt *route) *httputil.ReverseProxy { return nil })
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"other.com", "/api/users", "api"},
+		{"other.com", "/apix", ""},
+		{"other.com", "/api/v2/users", "api-v2"},
+		{"example.com", "/api/v2/users", "host"},
+		{"www.example.com", "/api/v2/users", "wildcard"},
+		{"www.example.com", "/api/v2/admin/x", "priority"},
+		{"example.com", "/", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.path, nil)
+		got := ""
+		if rt := table.match(req); rt != nil {
+			got = rt.rule.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s%s 应匹配规则 %q，实际 %q", tt.host, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestRouterEmptyAndDuplicateIDs(t *testing.T) {
+	a := newTestUpstream(t, "a")
+	b := newTestUpstream(t, "b")
+	c := newTestUpstream(t, "c")
+
+	// 手动编辑的规则文件中 ID 可能为空或重复
+	rules := []config.ProxyRule{
+		{ID: "uuid", Name: "a", Path: "/a", Target: a.URL},
+		{ID: "uuid", Name: "b", Path: "/b", Target: b.URL},
+		{Name: "c", Path: "/c", Target: c.URL},
+	}
+
+	pm := &ProxyManager{}
+	for i := 0; i < 2; i++ {
+		// 第二次构建时复用旧路由表，ID 重复的规则不能共用运行时状态
+		pm.routes.Store(buildRouteTable(rules, pm.routes.Load(), pm.newReverseProxy))
+
+		for _, want := range []string{"a", "b", "c"} {
+			code, body := serve(pm, "localhost", "/"+want+"/")
+			if code != http.StatusOK || body != want {
+				t.Fatalf("第 %d 次构建后 /%s/ 应返回 %q，实际 %d %q", i+1, want, want, code, body)
+			}
+		}
+	}
+}
+
+func TestHandlerReservedPaths(t *testing.T) {
+	site := newTestUpstream(t, "site")
+	catchAll := newTestUpstream(t, "any")
+	pm := newTestProxyManager([]config.ProxyRule{
+		{ID: "site", Name: "site", Host: "example.com", Target: site.URL},
+		{ID: "any", Name: "any", Path: "/app", Target: catchAll.URL},
+	})
+
+	admin := http.NewServeMux()
+	admin.HandleFunc("/api/rules", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "admin") })
+	admin.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "admin") })
+	admin.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "home")
+	})
+
+	tests := []struct {
+		adminHost, host, path string
+		code                  int
+		body                  string
+	}{
+		// 配置了域名的规则拥有该域名下的 /api/ 和 /admin/
+		{"", "example.com", "/api/rules", http.StatusOK, "site"},
+		{"", "example.com", "/admin/", http.StatusOK, "site"},
+		{"", "other.com", "/api/rules", http.StatusOK, "admin"},
+		{"", "other.com", "/app/x", http.StatusOK, "any"},
+		{"", "other.com", "/", http.StatusOK, "home"},
+		{"", "other.com", "/missing", http.StatusNotFound, ""},
+		// 指定管理域名后，其他域名不提供管理路径
+		{"admin.local", "admin.local", "/admin/", http.StatusOK, "admin"},
+		{"admin.local", "other.com", "/admin/", http.StatusNotFound, ""},
+		{"admin.local", "other.com", "/", http.StatusOK, "home"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.path, nil)
+		rec := httptest.NewRecorder()
+		pm.Handler(admin, tt.adminHost).ServeHTTP(rec, req)
+		if rec.Code != tt.code || tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("管理域名 %q 下 %s%s 应返回 %d %q，实际 %d %q", tt.adminHost, tt.host, tt.path, tt.code, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	// 客户端重写脚本在所有域名下提供，包括配置了域名的规则
+	rec := httptest.NewRecorder()
+	pm.Handler(admin, "admin.local").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+ShimPath, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/javascript; charset=utf-8" {
+		t.Errorf("%s 应返回客户端脚本，实际 %d %s", ShimPath, rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestUpstreamPathRewrite(t *testing.T) {
+	upstream := newEchoUpstream(t, "up")
+	tests := []struct {
+		name string
+		rule config.ProxyRule
+		path string
+		want string
+	}{
+		{
+			"正则模板", config.ProxyRule{MatchRegex: `^/u/(\d+)/(.*)`, Rewrite: "/users/$1/$2"},
+			"/u/42/posts/7?x=1", "up /base/users/42/posts/7?x=1",
+		},
+		{
+			"命名捕获组", config.ProxyRule{Path: "/u", MatchRegex: `^/u/(?P<id>\d+)$`, Rewrite: "profile/${id}"},
+			"/u/42", "up /base/profile/42",
+		},
+		{"去掉前缀", config.ProxyRule{Path: "/api"}, "/api/v1/items", "up /base/v1/items"},
+		{"保留前缀", config.ProxyRule{Path: "/api", KeepPrefix: true}, "/api/v1/items", "up /base/api/v1/items"},
+		{"前缀本身", config.ProxyRule{Path: "/api"}, "/api", "up /base/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			rule.ID, rule.Name, rule.Target = "r", "r", upstream.URL+"/base"
+			code, body := serve(newTestProxyManager([]config.ProxyRule{rule}), "localhost", tt.path)
+			if code != http.StatusOK || body != tt.want {
+				t.Fatalf("应返回 %q，实际 %d %q", tt.want, code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 安全响应头的处理方式
+const (
+	PolicyKeep    = "keep"
+	PolicyRemove  = "remove"
+	PolicyRewrite = "rewrite"
+)
+
+// cspHeaders 内容安全策略相关的响应头
+var cspHeaders = []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"}
+
+// crossOriginHeaders 跨源隔离相关的响应头
+var crossOriginHeaders = []string{"Cross-Origin-Opener-Policy", "Cross-Origin-Embedder-Policy", "Cross-Origin-Resource-Policy"}
+
+// applySecurityHeaders 按规则移除或改写上游的安全响应头
+// 这些头通常引用上游域名，页面挂载到代理前缀下后会导致资源或嵌入被浏览器拦截
+func applySecurityHeaders(h http.Header, cfg config.SecurityHeaders, links *linkRewriter) {
+	switch cfg.CSP {
+	case PolicyRemove:
+		for _, name := range cspHeaders {
+			h.Del(name)
+		}
+	case PolicyRewrite:
+		for _, name := range cspHeaders {
+			values := h.Values(name)
+			for i, v := range values {
+				values[i] = rewriteCSP(v, links)
+			}
+		}
+	}
+
+	if cfg.FrameOptions == PolicyRemove {
+		h.Del("X-Frame-Options")
+	}
+	if cfg.HSTS == PolicyRemove {
+		h.Del("Strict-Transport-Security")
+	}
+	if cfg.CrossOrigin == PolicyRemove {
+		for _, name := range crossOriginHeaders {
+			h.Del(name)
+		}
+	}
+}
+
+// rewriteCSP 将 CSP 指令中指向上游的来源替换为 'self'
+// report-uri 和 report-to 的值是上报地址或端点名称而不是来源，保持不变
+func rewriteCSP(policy string, links *linkRewriter) string {
+	directives := strings.Split(policy, ";")
+	for i, d := range directives {
+		fields := strings.Fields(d)
+		if len(fields) < 2 || strings.EqualFold(fields[0], "report-uri") || strings.EqualFold(fields[0], "report-to") {
+			continue
+		}
+
+		out := []string{fields[0]}
+		hasSelf := false
+		for _, src := range fields[1:] {
+			if links.matchesSource(src) {
+				src = "'self'"
+			}
+			if strings.EqualFold(src, "'self'") {
+				if hasSelf {
+					continue
+				}
+				hasSelf = true
+			}
+			out = append(out, src)
+		}
+		directives[i] = " " + strings.Join(out, " ")
+	}
+	return strings.TrimSpace(strings.Join(directives, ";"))
+}
+
+// matchesSource 判断 CSP 来源表达式是否指向任一上游域名，支持 *.example.com 形式的通配
+func (lr *linkRewriter) matchesSource(src string) bool {
+	// 跳过 'self'、'nonce-xxx' 等关键字和 https: 等仅包含协议的来源
+	if strings.HasPrefix(src, "'") {
+		return false
+	}
+	host := src
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	} else if strings.HasSuffix(host, ":") {
+		return false
+	}
+	host, _, _ = strings.Cut(host, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, o := range lr.origins {
+		originHost := o.host
+		if h, _, err := net.SplitHostPort(originHost); err == nil {
+			originHost = h
+		}
+		if host == originHost {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(host, "*"); ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(originHost, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRewriteCSP(t *testing.T) {
+	links := newTestLinks(t, "/p", "https://www.example.com:8443/app")
+	cdn, _ := url.Parse("https://cdn.example.com")
+	links.addOrigin(cdn, "/p/__host/cdn.example.com")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"上游来源", "default-src https://www.example.com", "default-src 'self'"},
+		{"带端口的来源", "img-src www.example.com:8443 https://www.example.com:443/x", "img-src 'self'"},
+		{"额外源站", "script-src https://cdn.example.com/js/", "script-src 'self'"},
+		{"通配来源", "script-src *.example.com", "script-src 'self'"},
+		{"通配不匹配", "script-src *.other.com https://example.com", "script-src *.other.com https://example.com"},
+		{"去重 'self'", "default-src 'self' https://www.example.com 'SELF' cdn.example.com", "default-src 'self'"},
+		{"保留关键字和协议来源", "script-src 'nonce-abc' 'unsafe-inline' https: data: www.example.com", "script-src 'nonce-abc' 'unsafe-inline' https: data: 'self'"},
+		{
+			"上报指令保持不变",
+			"default-src www.example.com; report-uri https://www.example.com/csp; report-to www.example.com",
+			"default-src 'self'; report-uri https://www.example.com/csp; report-to www.example.com",
+		},
+		{"多个指令", "default-src 'none';  img-src https://www.example.com ;", "default-src 'none'; img-src 'self';"},
+		{"没有来源的指令", "upgrade-insecure-requests; frame-src cdn.example.com", "upgrade-insecure-requests; frame-src 'self'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteCSP(tt.in, links); got != tt.want {
+				t.Fatalf("应改写为 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplySecurityHeaders(t *testing.T) {
+	links := newTestLinks(t, "/p", "https://www.example.com")
+	h := http.Header{}
+	h.Set("Content-Security-Policy", "default-src https://www.example.com")
+	h.Set("Content-Security-Policy-Report-Only", "img-src https://www.example.com; report-uri /csp")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Strict-Transport-Security", "max-age=63072000")
+	h.Set("Cross-Origin-Opener-Policy", "same-origin")
+
+	applySecurityHeaders(h, config.SecurityHeaders{CSP: PolicyRewrite, FrameOptions: PolicyRemove, CrossOrigin: PolicyRemove}, links)
+
+	if v := h.Get("Content-Security-Policy"); v != "default-src 'self'" {
+		t.Errorf("CSP 改写错误: %q", v)
+	}
+	if v := h.Get("Content-Security-Policy-Report-Only"); v != "img-src 'self'; report-uri /csp" {
+		t.Errorf("仅报告模式的 CSP 改写错误: %q", v)
+	}
+	if h.Get("X-Frame-Options") != "" || h.Get("Cross-Origin-Opener-Policy") != "" {
+		t.Error("设置为 remove 的头应被删除")
+	}
+	if h.Get("Strict-Transport-Security") == "" {
+		t.Error("未配置的头应保持不变")
+	}
+}
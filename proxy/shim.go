@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ShimPath 客户端重写脚本的访问路径
+const ShimPath = "/__proxy_every/shim.js"
+
+//go:embed assets/shim.js
+var shimScript []byte
+
+// shimETag 脚本内容的 ETag，脚本随程序发布，内容不变时浏览器可直接使用缓存
+var shimETag = func() string {
+	sum := sha256.Sum256(shimScript)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}()
+
+// ServeShim 输出客户端重写脚本
+func ServeShim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", shimETag)
+	http.ServeContent(w, r, "shim.js", time.Time{}, bytes.NewReader(shimScript))
+}
+
+// shimTag 生成注入页面的 script 标签，前缀、上游路径、上游地址和 Cookie 命名空间通过 data 属性传给脚本
+// 每个上游地址的格式为 host/path=base，base 为其映射到的代理路径
+func (lr *linkRewriter) shimTag(namespace string) string {
+	origins := make([]string, 0, len(lr.origins))
+	for _, o := range lr.origins {
+		origins = append(origins, o.host+o.path+"="+o.base)
+	}
+
+	return `<script src="` + ShimPath + `" data-prefix="` + html.EscapeString(lr.prefix) +
+		`" data-root="` + html.EscapeString(lr.root) +
+		`" data-origins="` + html.EscapeString(strings.Join(origins, " ")) +
+		`" data-cookie-namespace="` + html.EscapeString(namespace) + `"></script>`
+}
@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShimTag(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	tag := links.shimTag("pe1234_")
+	for _, attr := range []string{
+		`data-prefix="/p"`,
+		`data-root="/app"`,
+		`data-origins="up/app=/p"`,
+		`data-cookie-namespace="pe1234_"`,
+	} {
+		if !strings.Contains(tag, attr) {
+			t.Errorf("脚本标签应包含 %s: %s", attr, tag)
+		}
+	}
+}
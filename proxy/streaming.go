@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+)
+
+// streamingTypes 流式响应的内容类型，这类响应需要边收边发，不能缓冲或重写
+var streamingTypes = []string{
+	"text/event-stream",
+	"application/x-ndjson",
+	"application/stream+json",
+	"application/jsonl",
+	"multipart/x-mixed-replace",
+	"application/grpc",
+}
+
+// isStreaming 判断响应是否为流式响应，包括规则中额外配置的内容类型
+func (rt *route) isStreaming(contentType string) bool {
+	mt := mediaType(contentType)
+	if mt == "" {
+		return false
+	}
+	for _, t := range streamingTypes {
+		// application/grpc 包括 application/grpc+proto 等子类型
+		if mt == t || strings.HasPrefix(mt, t+"+") {
+			return true
+		}
+	}
+	for _, t := range rt.rule.Streaming.ContentTypes {
+		if strings.EqualFold(strings.TrimSpace(t), mt) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushInterval 规则的响应刷新间隔，负数表示每次写入后立即刷新
+func (rt *route) flushInterval() time.Duration {
+	if rt.rule.Streaming.FlushInterval < 0 {
+		return -1
+	}
+	return time.Duration(rt.rule.Streaming.FlushInterval) * time.Millisecond
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bufio"
+	"go_proxy_every/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsStreaming(t *testing.T) {
+	rt := &route{rule: config.ProxyRule{Streaming: config.StreamingConfig{ContentTypes: []string{" Application/X-Custom-Stream "}}}}
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/event-stream", true},
+		{"Text/Event-Stream; charset=utf-8", true},
+		{"application/x-ndjson", true},
+		{"application/grpc", true},
+		{"application/grpc+proto", true},
+		{"application/x-custom-stream", true},
+		{"application/x-custom-stream; boundary=x", true},
+		{"text/html", false},
+		{"application/json", false},
+		{"application/grpc-web", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := rt.isStreaming(tt.contentType); got != tt.want {
+			t.Errorf("%q 是否为流式响应应为 %v，实际 %v", tt.contentType, tt.want, got)
+		}
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	tests := []struct {
+		value int
+		want  time.Duration
+	}{
+		{0, 0},
+		{-1, -1},
+		{-5, -1},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		rt := &route{rule: config.ProxyRule{Streaming: config.StreamingConfig{FlushInterval: tt.value}}}
+		if got := rt.flushInterval(); got != tt.want {
+			t.Errorf("flush_interval %d 应为 %v，实际 %v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestStreamingPassThrough(t *testing.T) {
+	const event = "data: secret <a href=\"/x\">\n\n"
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/x-custom-stream")
+		}
+		io.WriteString(w, event)
+		w.(http.Flusher).Flush()
+		// 上游保持连接，稍后才发送下一个事件
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+		}
+		io.WriteString(w, "data: done\n\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	// 规则开启了内容替换和链接重写，流式响应都应跳过
+	proxy := httptest.NewServer(newTestProxyManager([]config.ProxyRule{{
+		ID: "sse", Name: "sse", Path: "/sse", Target: upstream.URL,
+		Rewriting:     config.RewriteOptions{RootRelative: true, JS: true, CSS: true},
+		Substitutions: []config.Substitution{{Find: "secret", Replace: "******", ContentTypes: []string{"*/*"}}},
+		Streaming:     config.StreamingConfig{ContentTypes: []string{"application/x-custom-stream"}},
+	}}))
+	defer proxy.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for _, path := range []string{"/sse/events", "/sse/custom"} {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 第一个事件应在上游结束前到达，且内容未被替换、重写或压缩
+		br := bufio.NewReader(resp.Body)
+		var got string
+		for len(got) < len(event) {
+			line, err := br.ReadString('\n')
+			got += line
+			if err != nil {
+				t.Fatalf("%s 读取第一个事件失败: %v", path, err)
+			}
+		}
+		resp.Body.Close()
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s 第一个事件应立即到达，实际等待 %v", path, elapsed)
+		}
+		if got != event {
+			t.Fatalf("%s 流式响应不应被修改，实际 %q", path, got)
+		}
+		if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+			t.Fatalf("%s 流式响应不应重新压缩，实际 %s", path, ce)
+		}
+	}
+}
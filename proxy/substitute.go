@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"go_proxy_every/config"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// substitution 编译后的内容替换规则
+type substitution struct {
+	find         []byte
+	replace      []byte
+	re           *regexp.Regexp
+	contentTypes []string
+}
+
+// compileSubstitutions 编译规则的内容替换，无效的正则记录日志后跳过
+func compileSubstitutions(rule config.ProxyRule) []substitution {
+	var subs []substitution
+	for _, s := range rule.Substitutions {
+		if s.Find == "" {
+			continue
+		}
+		sub := substitution{find: []byte(s.Find), replace: []byte(s.Replace)}
+		if s.Regex {
+			re, err := regexp.Compile(s.Find)
+			if err != nil {
+				log.Printf("[Router] 规则 %s 的替换正则无效，已跳过: %v", rule.Name, err)
+				continue
+			}
+			sub.re = re
+		}
+		for _, ct := range s.ContentTypes {
+			if ct = strings.ToLower(strings.TrimSpace(ct)); ct != "" {
+				sub.contentTypes = append(sub.contentTypes, ct)
+			}
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// matches 判断替换规则是否对该媒体类型生效
+func (s substitution) matches(mt string) bool {
+	if len(s.contentTypes) == 0 {
+		return true
+	}
+	for _, ct := range s.contentTypes {
+		if ct == mt || ct == "*/*" {
+			return true
+		}
+		// 支持 text/* 形式的通配
+		if base, ok := strings.CutSuffix(ct, "/*"); ok && strings.HasPrefix(mt, base+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 对内容执行替换
+func (s substitution) apply(data []byte) []byte {
+	if s.re != nil {
+		return s.re.ReplaceAll(data, s.replace)
+	}
+	return bytes.ReplaceAll(data, s.find, s.replace)
+}
+
+// substitutionsFor 返回对该响应生效的替换规则，只处理文本类型
+func (rt *route) substitutionsFor(contentType string) []substitution {
+	if len(rt.subs) == 0 {
+		return nil
+	}
+	mt := mediaType(contentType)
+	if !isTextType(mt) {
+		return nil
+	}
+
+	var subs []substitution
+	for _, s := range rt.subs {
+		if s.matches(mt) {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+// maxBodySize 内容替换时缓冲的最大响应体
+func (rt *route) maxBodySize() int64 {
+	if rt.rule.MaxBodySize > 0 {
+		return rt.rule.MaxBodySize
+	}
+	return maxTextRewriteSize
+}
+
+// applySubstitutions 依次执行替换规则
+func applySubstitutions(data []byte, subs []substitution) []byte {
+	for _, s := range subs {
+		data = s.apply(data)
+	}
+	return data
+}
+
+// isTextType 判断媒体类型是否为文本内容
+func isTextType(mt string) bool {
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/ecmascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
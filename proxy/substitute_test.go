@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSubstitutionMatches(t *testing.T) {
+	tests := []struct {
+		contentTypes []string
+		mt           string
+		want         bool
+	}{
+		{nil, "text/html", true},
+		{[]string{"text/html"}, "text/html", true},
+		{[]string{"text/html"}, "text/css", false},
+		{[]string{"text/*"}, "text/css", true},
+		{[]string{"text/*"}, "application/json", false},
+		{[]string{"text/*"}, "textual/plain", false},
+		{[]string{"application/*"}, "application/json", true},
+		{[]string{"*/*"}, "application/xml", true},
+		{[]string{"application/json", "text/*"}, "text/plain", true},
+	}
+	for _, tt := range tests {
+		rule := config.ProxyRule{Substitutions: []config.Substitution{{Find: "a", ContentTypes: tt.contentTypes}}}
+		if got := compileSubstitutions(rule)[0].matches(tt.mt); got != tt.want {
+			t.Errorf("%v 对 %s 应返回 %v", tt.contentTypes, tt.mt, tt.want)
+		}
+	}
+}
+
+func TestCompileSubstitutionsNormalizesContentTypes(t *testing.T) {
+	rule := config.ProxyRule{Substitutions: []config.Substitution{
+		{Find: ""},
+		{Find: "(", Regex: true},
+		{Find: "a", ContentTypes: []string{" Text/HTML ", ""}},
+	}}
+	subs := compileSubstitutions(rule)
+	if len(subs) != 1 {
+		t.Fatalf("空查找和无效正则应被跳过，实际 %d 条", len(subs))
+	}
+	if !subs[0].matches("text/html") || len(subs[0].contentTypes) != 1 {
+		t.Fatalf("内容类型应规范为小写并去掉空项: %q", subs[0].contentTypes)
+	}
+}
+
+func TestApplySubstitutions(t *testing.T) {
+	rule := config.ProxyRule{Substitutions: []config.Substitution{
+		{Find: "api.example.com", Replace: "proxy.local"},
+		{Find: `"version":\s*"(\d+)"`, Replace: `"version":"v$1"`, Regex: true},
+		// 按顺序执行，后面的规则作用于前面的结果
+		{Find: "proxy.local", Replace: "proxy.local/api"},
+	}}
+	subs := compileSubstitutions(rule)
+
+	got := string(applySubstitutions([]byte(`{"url":"https://api.example.com/x","version": "2"}`), subs))
+	if want := `{"url":"https://proxy.local/api/x","version":"v2"}`; got != want {
+		t.Fatalf("应替换为 %s，实际 %s", want, got)
+	}
+}
+
+func TestSubstitutionsThroughProxy(t *testing.T) {
+	big := strings.Repeat("x", 64) + "secret"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, "secret")
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, big)
+		case "/image":
+			w.Header().Set("Content-Type", "image/svg")
+			io.WriteString(w, "secret")
+		case "/range":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-5/100")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, "secret")
+		}
+	}))
+	defer upstream.Close()
+
+	rules := []config.ProxyRule{{
+		ID: "sub", Name: "sub", Path: "/sub", Target: upstream.URL, MaxBodySize: 32,
+		Substitutions: []config.Substitution{{Find: "secret", Replace: "******", ContentTypes: []string{"text/*"}}},
+	}}
+	pm := newTestProxyManager(rules)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/sub/small", http.StatusOK, "******"},
+		{"/sub/big", http.StatusOK, big},
+		{"/sub/image", http.StatusOK, "secret"},
+		{"/sub/range", http.StatusPartialContent, "secret"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		pm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+tt.path, nil))
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s 应返回 %d %q，实际 %d %q", tt.path, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSubstitutionsWeakenETag(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		io.WriteString(w, "secret")
+	}))
+	defer upstream.Close()
+
+	pm := newTestProxyManager([]config.ProxyRule{{
+		ID: "sub", Name: "sub", Path: "/sub", Target: upstream.URL,
+		Substitutions: []config.Substitution{{Find: "secret", Replace: "******"}},
+	}})
+
+	w := httptest.NewRecorder()
+	pm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/sub/", nil))
+	if w.Body.String() != "******" {
+		t.Fatalf("内容应被替换，实际 %q", w.Body.String())
+	}
+	// 改写后的内容不能与上游的字节范围拼接
+	if etag := w.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("ETag 应改为弱 ETag，实际 %s", etag)
+	}
+	if ar := w.Header().Get("Accept-Ranges"); ar != "" {
+		t.Errorf("不应声明支持字节范围请求，实际 %s", ar)
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// maxTextRewriteSize CSS/JS 响应需要整体缓冲后重写，超过该大小时原样透传
+const maxTextRewriteSize = 5 << 20
+
+var (
+	// cssURLPattern 匹配 url(...)，如 url("/img/a.png")
+	cssURLPattern = regexp.MustCompile(`(?i)url\(\s*(['"]?)([^'")\s]+)(['"]?)\s*\)`)
+	// cssImportPattern 匹配 @import "/a.css"
+	cssImportPattern = regexp.MustCompile(`(?i)(@import\s+)(['"])([^'"]+)(['"])`)
+
+	// jsCallPattern 匹配 fetch("/x")、location.assign("/x")、location.replace("/x")
+	jsCallPattern = regexp.MustCompile("(\\b(?:fetch|location\\.assign|location\\.replace)\\(\\s*)(['\"`])(/[^'\"`]*)(['\"`])")
+	// jsLocationPattern 匹配 location.href = "/x"、location = "/x"
+	jsLocationPattern = regexp.MustCompile(`(\blocation(?:\.href)?\s*=\s*)(['"])(/[^'"]*)(['"])`)
+	// jsXHRPattern 匹配 xhr.open("GET", "/x")
+	jsXHRPattern = regexp.MustCompile(`(\.open\(\s*['"][A-Za-z]+['"]\s*,\s*)(['"])(/[^'"]*)(['"])`)
+	// jsAbsolutePattern 匹配字符串中的绝对地址和协议相对地址
+	jsAbsolutePattern = regexp.MustCompile("(['\"`])((?:https?:)?//[^'\"`\\s]+)(['\"`])")
+)
+
+// isCSSType 判断是否为 CSS 响应
+func isCSSType(contentType string) bool {
+	return mediaType(contentType) == "text/css"
+}
+
+// isJSType 判断是否为 JavaScript 响应
+func isJSType(contentType string) bool {
+	switch mediaType(contentType) {
+	case "application/javascript", "text/javascript", "application/x-javascript", "application/ecmascript", "text/ecmascript":
+		return true
+	}
+	return false
+}
+
+// mediaType 解析 Content-Type 中的媒体类型（小写，不含参数）
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// rewriteCSS 重写 CSS 中 url() 和 @import 引用的地址
+func rewriteCSS(css string, links *linkRewriter, root bool) string {
+	rewrite := links.rewrite
+	if root {
+		rewrite = links.rewriteWithRoot
+	}
+
+	css = cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		m := cssURLPattern.FindStringSubmatch(match)
+		if m[1] != m[3] {
+			return match
+		}
+		if v, ok := rewrite(m[2]); ok {
+			return "url(" + m[1] + v + m[3] + ")"
+		}
+		return match
+	})
+
+	return replaceQuoted(css, cssImportPattern, rewrite)
+}
+
+// rewriteJS 重写 JavaScript 中常见的地址写法
+// 上游绝对地址总是重写，根相对地址仅在 root 为 true 时重写
+// 先处理根相对地址，避免已改写为代理路径的绝对地址再次被添加前缀
+func rewriteJS(js string, links *linkRewriter, root bool) string {
+	if root {
+		for _, pattern := range []*regexp.Regexp{jsCallPattern, jsLocationPattern, jsXHRPattern} {
+			js = replaceQuoted(js, pattern, links.rewriteWithRoot)
+		}
+	}
+	return replaceQuoted(js, jsAbsolutePattern, links.rewrite)
+}
+
+// replaceQuoted 替换正则中引号包裹的地址
+// 正则的最后三个分组依次为：起始引号、地址、结束引号，之前的分组原样保留
+func replaceQuoted(s string, pattern *regexp.Regexp, rewrite func(string) (string, bool)) string {
+	return pattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := pattern.FindStringSubmatch(match)
+		n := len(m)
+		open, target, closing := m[n-3], m[n-2], m[n-1]
+		if open != closing {
+			return match
+		}
+		v, ok := rewrite(target)
+		if !ok {
+			return match
+		}
+		return strings.Join(m[1:n-3], "") + open + v + closing
+	})
+}
+
+// bufferedRewriter 缓冲整个响应体后整体重写，超过大小限制时原样输出
+type bufferedRewriter struct {
+	src       io.ReadCloser
+	limit     int64
+	transform func([]byte) []byte
+	out       io.Reader
+}
+
+// newBufferedRewriter 创建缓冲重写器，首次读取时才开始读取上游
+func newBufferedRewriter(src io.ReadCloser, limit int64, transform func([]byte) []byte) *bufferedRewriter {
+	return &bufferedRewriter{src: src, limit: limit, transform: transform}
+}
+
+// Read 实现 io.Reader
+func (b *bufferedRewriter) Read(p []byte) (int, error) {
+	if b.out == nil {
+		data, err := io.ReadAll(io.LimitReader(b.src, b.limit+1))
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(data)) > b.limit {
+			// 超过大小限制，已读部分与剩余部分原样输出
+			b.out = io.MultiReader(bytes.NewReader(data), b.src)
+		} else {
+			b.out = bytes.NewReader(b.transform(data))
+		}
+	}
+	return b.out.Read(p)
+}
+
+// Close 关闭上游响应体
+func (b *bufferedRewriter) Close() error {
+	return b.src.Close()
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import "testing"
+
+func TestRewriteCSS(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	tests := []struct {
+		name string
+		root bool
+		in   string
+		want string
+	}{
+		{"绝对地址", false, `a{background:url(http://up/app/img/a.png)}`, `a{background:url(/p/img/a.png)}`},
+		{"带引号", false, `a{background:url( "//up/app/a.png" )}`, `a{background:url("/p/a.png")}`},
+		{"引号不匹配", false, `a{background:url("http://up/app/a.png')}`, `a{background:url("http://up/app/a.png')}`},
+		{"未开启根相对地址", false, `a{background:url(/app/a.png)}`, `a{background:url(/app/a.png)}`},
+		{"根相对地址", true, `a{background:url('/app/a.png')}`, `a{background:url('/p/a.png')}`},
+		{"上游路径之外", true, `a{background:url(/other/a.png)}`, `a{background:url(/other/a.png)}`},
+		{"相对地址", true, `a{background:url(img/a.png)}`, `a{background:url(img/a.png)}`},
+		{"数据地址", true, `a{background:url(data:image/png;base64,AAAA)}`, `a{background:url(data:image/png;base64,AAAA)}`},
+		{"@import", true, `@import "/app/base.css"; @import 'http://up/app/x.css';`, `@import "/p/base.css"; @import '/p/x.css';`},
+		{"其他站点", true, `@import "https://cdn.example.com/x.css";`, `@import "https://cdn.example.com/x.css";`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteCSS(tt.in, links, tt.root); got != tt.want {
+				t.Fatalf("应重写为 %s，实际 %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRewriteJS(t *testing.T) {
+	links := newTestLinks(t, "/p", "http://up/app")
+	tests := []struct {
+		name string
+		root bool
+		in   string
+		want string
+	}{
+		{"绝对地址", false, `var api = "http://up/app/api";`, `var api = "/p/api";`},
+		{"协议相对地址", false, "var api = `//up/app/api`;", "var api = `/p/api`;"},
+		{"其他站点", false, `var api = "https://other/api";`, `var api = "https://other/api";`},
+		{"未开启根相对地址", false, `fetch("/app/api")`, `fetch("/app/api")`},
+		{"fetch", true, `fetch("/app/api", {method: "POST"})`, `fetch("/p/api", {method: "POST"})`},
+		{"location.href", true, `location.href = '/app/login'`, `location.href = '/p/login'`},
+		{"location", true, `window.location="/app/"`, `window.location="/p/"`},
+		{"location.replace", true, "location.replace(`/app/home`)", "location.replace(`/p/home`)"},
+		{"xhr.open", true, `xhr.open("GET", "/app/data?x=1")`, `xhr.open("GET", "/p/data?x=1")`},
+		{"上游路径之外", true, `fetch("/other/api")`, `fetch("/other/api")`},
+		{"普通字符串", true, `var s = "/app/api";`, `var s = "/app/api";`},
+		{"引号不匹配", true, `fetch("/app/api')`, `fetch("/app/api')`},
+		{"不重复添加前缀", true, `fetch("http://up/app/api")`, `fetch("/p/api")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteJS(tt.in, links, tt.root); got != tt.want {
+				t.Fatalf("应重写为 %s，实际 %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"go_proxy_every/config"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// 上游 HTTP/2 模式
+const (
+	HTTP2Auto = ""
+	HTTP2H2C  = "h2c"
+	HTTP2Off  = "off"
+)
+
+// HTTP2Modes 支持的上游 HTTP/2 模式
+var HTTP2Modes = []string{HTTP2Auto, HTTP2H2C, HTTP2Off}
+
+// upstreamTransport 上游传输层
+type upstreamTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// 上游连接池默认值，与 http.DefaultTransport 保持一致，并放宽每个主机的空闲连接数
+const (
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 32
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = 1 * time.Second
+)
+
+// seconds 将配置中的秒数转换为时长，未设置时使用默认值
+func seconds(value int, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+// newTransport 根据规则的连接配置和 TLS 配置创建上游 Transport
+func newTransport(cfg config.TransportConfig, tlsConfig *tls.Config) upstreamTransport {
+	dialer := &net.Dialer{
+		Timeout:   seconds(cfg.DialTimeout, defaultDialTimeout),
+		KeepAlive: seconds(cfg.KeepAlive, defaultKeepAlive),
+	}
+
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	maxIdleConnsPerHost := cfg.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       seconds(cfg.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   seconds(cfg.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	switch cfg.HTTP2 {
+	case HTTP2Off:
+		// 非空的 TLSNextProto 会禁止 HTTP/2 协商
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	case HTTP2H2C:
+		// h2c 连接直接拨号，不经过 HTTP_PROXY；一个连接上的请求共用多路复用的流，
+		// max_conns_per_host 只区分是否限制：设置后每个上游只保持一个连接，并发流用满时请求排队等待
+		return &h2cTransport{
+			tls: t,
+			h2c: &http2.Transport{
+				AllowHTTP: true,
+				// 明文连接上直接使用 HTTP/2（prior knowledge）
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+				DisableCompression:         true,
+				IdleConnTimeout:            seconds(cfg.IdleConnTimeout, defaultIdleConnTimeout),
+				StrictMaxConcurrentStreams: cfg.MaxConnsPerHost > 0,
+			},
+			responseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
+			disableKeepAlives:     cfg.DisableKeepAlives,
+		}
+	}
+	return t
+}
+
+// errResponseHeaderTimeout 等待 h2c 上游响应头超时
+var errResponseHeaderTimeout = fmt.Errorf("timeout awaiting response headers: %w", context.DeadlineExceeded)
+
+// h2cTransport http 上游使用明文 HTTP/2，https 上游仍通过 ALPN 协商
+type h2cTransport struct {
+	tls *http.Transport
+	h2c *http2.Transport
+	// http2.Transport 没有对应的设置，由 RoundTrip 实现
+	responseHeaderTimeout time.Duration
+	disableKeepAlives     bool
+}
+
+// RoundTrip 按上游协议选择传输层
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" {
+		return t.tls.RoundTrip(req)
+	}
+	if t.disableKeepAlives {
+		// 请求完成后关闭连接，不再复用
+		req = req.WithContext(req.Context())
+		req.Close = true
+	}
+	if t.responseHeaderTimeout <= 0 {
+		return t.h2c.RoundTrip(req)
+	}
+
+	// 只限制等待响应头的时间，收到响应头后响应体可以持续传输
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.responseHeaderTimeout, cancel)
+	resp, err := t.h2c.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, errResponseHeaderTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 关闭响应体时释放请求的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 关闭响应体
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// CloseIdleConnections 关闭空闲连接
+func (t *h2cTransport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+// originTransport 额外源站（如 CDN）是公网服务，不使用规则中为上游配置的 CA、客户端证书和 SNI
+type originTransport struct {
+	upstream upstreamTransport
+	extra    upstreamTransport
+}
+
+// RoundTrip 按请求的目标选择传输层，健康检查等不经过代理的请求视为上游请求
+func (t *originTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if state := stateFromContext(req.Context()); state != nil && state.extra {
+		return t.extra.RoundTrip(req)
+	}
+	return t.upstream.RoundTrip(req)
+}
+
+// CloseIdleConnections 关闭空闲连接
+func (t *originTransport) CloseIdleConnections() {
+	t.upstream.CloseIdleConnections()
+	t.extra.CloseIdleConnections()
+}
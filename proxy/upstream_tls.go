@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go_proxy_every/config"
+	"os"
+	"strings"
+)
+
+// tlsVersions 支持配置的最低 TLS 版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ValidateUpstreamTLS 校验上游 TLS 配置，证书文件不存在或内容无效时返回错误
+func ValidateUpstreamTLS(cfg config.UpstreamTLS) error {
+	_, err := newUpstreamTLSConfig(cfg)
+	return err
+}
+
+// newUpstreamTLSConfig 根据规则创建连接上游使用的 TLS 配置，未做任何配置时返回 nil 使用默认设置
+func newUpstreamTLSConfig(cfg config.UpstreamTLS) (*tls.Config, error) {
+	if cfg == (config.UpstreamTLS{}) {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         strings.TrimSpace(cfg.ServerName),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if v := strings.TrimSpace(cfg.MinVersion); v != "" {
+		version, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("不支持的最低 TLS 版本 %q", v)
+		}
+		tc.MinVersion = version
+	}
+
+	if strings.TrimSpace(cfg.CA) != "" {
+		data, err := loadPEM(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("CA 证书中没有有效的 PEM 证书")
+		}
+		tc.RootCAs = pool
+	}
+
+	hasCert, hasKey := strings.TrimSpace(cfg.Cert) != "", strings.TrimSpace(cfg.Key) != ""
+	if hasCert != hasKey {
+		return nil, errors.New("客户端证书和私钥必须同时设置")
+	}
+	if hasCert {
+		certPEM, err := loadPEM(cfg.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		keyPEM, err := loadPEM(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端私钥失败: %w", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("客户端证书无效: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+// loadPEM 读取 PEM 内容，以 -----BEGIN 开头时视为内联内容，否则视为文件路径
+func loadPEM(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
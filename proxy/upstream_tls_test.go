@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"go_proxy_every/config"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的私有 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// newTestCA 生成自签名 CA
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+// issue 签发证书，返回 PEM 格式的证书和私钥
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage, ips ...net.IP) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// newTLSUpstream 启动 HTTPS 上游，响应内容为收到的 SNI 和客户端证书的名称
+func newTLSUpstream(t *testing.T, ca *testCA, name string, clientAuth tls.ClientAuthType, maxVersion uint16, ips ...net.IP) *httptest.Server {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageServerAuth, ips...)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := "none"
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		fmt.Fprintf(w, "sni=%s client=%s", r.TLS.ServerName, client)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		ClientCAs:    pool,
+		MaxVersion:   maxVersion,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	other := newTestCA(t, "other ca")
+	upstream := newTLSUpstream(t, ca, "upstream.internal", tls.RequireAndVerifyClientCert, 0)
+	tls12 := newTLSUpstream(t, ca, "upstream.internal", tls.RequireAndVerifyClientCert, tls.VersionTLS12)
+	clientCert, clientKey := ca.issue(t, "proxy-client", x509.ExtKeyUsageClientAuth)
+
+	full := config.UpstreamTLS{CA: ca.pem, Cert: clientCert, Key: clientKey, ServerName: "upstream.internal"}
+	tests := []struct {
+		name   string
+		target string
+		tls    config.UpstreamTLS
+		code   int
+		body   string
+	}{
+		{"自定义 CA、客户端证书和 SNI", upstream.URL, full, http.StatusOK, "sni=upstream.internal client=proxy-client"},
+		{"不设置 server_name 时按地址校验失败", upstream.URL, config.UpstreamTLS{CA: ca.pem, Cert: clientCert, Key: clientKey}, http.StatusBadGateway, ""},
+		{"只信任配置的 CA", upstream.URL, config.UpstreamTLS{CA: other.pem, Cert: clientCert, Key: clientKey, ServerName: "upstream.internal"}, http.StatusBadGateway, ""},
+		{"上游要求客户端证书", upstream.URL, config.UpstreamTLS{CA: ca.pem, ServerName: "upstream.internal"}, http.StatusBadGateway, ""},
+		{"最低版本 1.2", tls12.URL, func() config.UpstreamTLS { c := full; c.MinVersion = "1.2"; return c }(), http.StatusOK, "sni=upstream.internal client=proxy-client"},
+		{"最低版本 1.3 高于上游", tls12.URL, func() config.UpstreamTLS { c := full; c.MinVersion = "1.3"; return c }(), http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := newTestProxyManager([]config.ProxyRule{{ID: "tls", Name: "tls", Path: "/tls", Target: tt.target, TLS: tt.tls}})
+			code, body := serve(pm, "localhost", "/tls/")
+			if code != tt.code || tt.body != "" && body != tt.body {
+				t.Fatalf("应返回 %d %q，实际 %d %q", tt.code, tt.body, code, body)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	tc, err := newUpstreamTLSConfig(config.UpstreamTLS{CA: ca.pem})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 设置 CA 后只信任这些 CA，不再使用系统根证书
+	want := x509.NewCertPool()
+	want.AddCert(ca.cert)
+	if !tc.RootCAs.Equal(want) {
+		t.Fatal("RootCAs 应只包含配置的 CA")
+	}
+
+	if tc, err := newUpstreamTLSConfig(config.UpstreamTLS{}); tc != nil || err != nil {
+		t.Fatalf("未配置时应使用默认设置，实际 %v %v", tc, err)
+	}
+	for _, v := range []string{"1.4", "tls1.2", "1"} {
+		if _, err := newUpstreamTLSConfig(config.UpstreamTLS{MinVersion: v}); err == nil {
+			t.Errorf("最低版本 %q 应被拒绝", v)
+		}
+	}
+	// 配置无效的规则不会进入路由表
+	pm := newTestProxyManager([]config.ProxyRule{{ID: "bad", Path: "/bad", Target: "https://127.0.0.1:1", TLS: config.UpstreamTLS{MinVersion: "1.4"}}})
+	if len(pm.routes.Load().routes) != 0 {
+		t.Fatal("最低版本无效的规则应被跳过")
+	}
+}
+
+func TestUpstreamTLSExtraOriginWithoutClientCert(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	upstream := newTLSUpstream(t, ca, "upstream.internal", tls.RequireAndVerifyClientCert, 0)
+	cdn := newTLSUpstream(t, ca, "cdn.internal", tls.RequestClientCert, 0, net.ParseIP("127.0.0.1"))
+	clientCert, clientKey := ca.issue(t, "proxy-client", x509.ExtKeyUsageClientAuth)
+
+	pm := newTestProxyManager([]config.ProxyRule{{
+		ID: "tls", Name: "tls", Path: "/tls", Target: upstream.URL, ExtraOrigins: []string{cdn.URL},
+		TLS: config.UpstreamTLS{CA: ca.pem, Cert: clientCert, Key: clientKey, ServerName: "upstream.internal"},
+	}})
+
+	// 额外源站使用默认 TLS 设置，这里让它像信任公网 CA 一样信任测试 CA
+	rt := pm.routes.Load().routes[0]
+	extra := rt.transport.(*originTransport).extra.(*http.Transport)
+	if extra.TLSClientConfig != nil {
+		t.Fatal("额外源站不应使用规则的 TLS 配置")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	extra.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	code, body := serve(pm, "localhost", "/tls/")
+	if code != http.StatusOK || body != "sni=upstream.internal client=proxy-client" {
+		t.Fatalf("上游应收到客户端证书，实际 %d %q", code, body)
+	}
+	u, _ := url.Parse(cdn.URL)
+	code, body = serve(pm, "localhost", "/tls/__host/"+u.Host+"/x")
+	if code != http.StatusOK || !strings.HasSuffix(body, "client=none") {
+		t.Fatalf("额外源站不应收到客户端证书，实际 %d %q", code, body)
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wsCounter 规则的 WebSocket 连接计数，按规则ID保存，规则修改后继续累计
+type wsCounter struct {
+	active atomic.Int64
+	total  atomic.Uint64
+}
+
+// WebSocketStats 规则的 WebSocket 连接统计
+type WebSocketStats struct {
+	RuleID         string `json:"rule_id"`
+	RuleName       string `json:"rule_name"`
+	Active         int64  `json:"active"`          // 当前连接数
+	Total          uint64 `json:"total"`           // 累计连接数
+	MaxConnections int    `json:"max_connections"` // 连接数上限，0 表示不限制
+}
+
+// isWebSocketRequest 判断是否为 WebSocket 升级请求
+func isWebSocketRequest(r *http.Request) bool {
+	if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Upgrade")), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsCounter 获取规则的 WebSocket 连接计数
+func (pm *ProxyManager) wsCounter(ruleID string) *wsCounter {
+	if c, ok := pm.websockets.Load(ruleID); ok {
+		return c.(*wsCounter)
+	}
+	c, _ := pm.websockets.LoadOrStore(ruleID, &wsCounter{})
+	return c.(*wsCounter)
+}
+
+// WebSocketStats 获取所有启用规则的 WebSocket 连接统计
+func (pm *ProxyManager) WebSocketStats() []WebSocketStats {
+	rules := pm.configManager.GetEnabledRules()
+	result := make([]WebSocketStats, 0, len(rules))
+	for _, rule := range rules {
+		c := pm.wsCounter(rule.ID)
+		result = append(result, WebSocketStats{
+			RuleID:         rule.ID,
+			RuleName:       rule.Name,
+			Active:         c.active.Load(),
+			Total:          c.total.Load(),
+			MaxConnections: rule.WebSocket.MaxConnections,
+		})
+	}
+	return result
+}
+
+// serveWebSocket 代理 WebSocket 升级请求，限制连接数、空闲时间和最长持续时间
+// 升级后的双向转发由 ReverseProxy 完成，连接关闭后 ServeHTTP 才返回
+func (pm *ProxyManager) serveWebSocket(w http.ResponseWriter, r *http.Request, rt *route) {
+	cfg := rt.rule.WebSocket
+	if cfg.Disabled {
+		http.Error(w, "WebSocket is disabled for this rule", http.StatusForbidden)
+		return
+	}
+
+	counter := pm.wsCounter(rt.rule.ID)
+	if n := counter.active.Add(1); cfg.MaxConnections > 0 && n > int64(cfg.MaxConnections) {
+		counter.active.Add(-1)
+		http.Error(w, "Too many WebSocket connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer counter.active.Add(-1)
+	counter.total.Add(1)
+
+	ww := &wsResponseWriter{
+		ResponseWriter: w,
+		idleTimeout:    seconds(cfg.IdleTimeout, 0),
+		maxDuration:    seconds(cfg.MaxDuration, 0),
+	}
+	pm.serveRoute(ww, r, rt)
+}
+
+// wsResponseWriter 在 ReverseProxy 接管连接时包装客户端连接，以施加时间限制
+type wsResponseWriter struct {
+	http.ResponseWriter
+	idleTimeout time.Duration
+	maxDuration time.Duration
+}
+
+// Hijack 接管客户端连接
+func (w *wsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.idleTimeout <= 0 && w.maxDuration <= 0 {
+		return conn, brw, nil
+	}
+	return newLimitedConn(conn, w.idleTimeout, w.maxDuration), brw, nil
+}
+
+// Unwrap 供 http.ResponseController 访问原始 ResponseWriter
+func (w *wsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// limitedConn 超过空闲时间或最长持续时间后自动关闭的连接
+// 任一方向有数据传输都视为活动
+type limitedConn struct {
+	net.Conn
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	maxTimer    *time.Timer
+	closeOnce   sync.Once
+}
+
+// newLimitedConn 创建带时间限制的连接，时长为 0 表示不限制
+// 计时器回调只关闭底层连接，不访问 limitedConn 的字段，避免与创建过程产生数据竞争
+func newLimitedConn(conn net.Conn, idleTimeout, maxDuration time.Duration) *limitedConn {
+	c := &limitedConn{Conn: conn, idleTimeout: idleTimeout}
+	if idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(idleTimeout, func() { conn.Close() })
+	}
+	if maxDuration > 0 {
+		c.maxTimer = time.AfterFunc(maxDuration, func() { conn.Close() })
+	}
+	return c
+}
+
+// touch 记录一次活动，重置空闲计时
+func (c *limitedConn) touch() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// Read 读取客户端数据
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Write 向客户端写入数据
+func (c *limitedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Close 关闭连接并停止计时器
+func (c *limitedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		if c.maxTimer != nil {
+			c.maxTimer.Stop()
+		}
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"go_proxy_every/config"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimitedConnIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := newLimitedConn(client, 50*time.Millisecond, 0)
+	defer c.Close()
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 持续写入时不会因空闲超时被关闭
+	for i := 0; i < 5; i++ {
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatalf("活动中的连接被关闭: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("空闲超时后连接应被关闭")
+	}
+}
+
+func TestLimitedConnMaxDuration(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := newLimitedConn(client, time.Second, 30*time.Millisecond)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("超过最长持续时间后读取应失败")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("超过最长持续时间后连接未关闭")
+	}
+}
+
+func TestWebSocketCountersPrunedWithRules(t *testing.T) {
+	cm := config.NewManager(filepath.Join(t.TempDir(), "rules.json"))
+	if err := cm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.AddRule(config.ProxyRule{ID: "ws", Name: "ws", Path: "/ws", Target: "http://127.0.0.1:1", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	pm := NewProxyManager(cm)
+	pm.wsCounter("ws").total.Add(1)
+
+	if err := cm.DeleteRule("ws"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pm.websockets.Load("ws"); ok {
+		t.Fatal("删除规则后应清理其 WebSocket 连接计数")
+	}
+}